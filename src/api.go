@@ -5,6 +5,7 @@ import (
 	"base"
 	"compress/gzip"
 	"fmt"
+	"gallery"
 	"io"
 	"io/ioutil"
 	"log"
@@ -150,6 +151,29 @@ func replace_path(target string, new string, old string) error {
 	return nil
 }
 
+// Resizes original images of uploaded entries, so that their
+// checksums and sizes are known before the entries are read.
+func derive_images(settings base.SiteSettings, directory string) error {
+	_, err := gallery.DeriveOriginals(directory, gallery.Options{
+		Widths:    settings.ImageWidths,
+		Types:     settings.ImageTypes,
+		MaxPixels: settings.ImageMaxPixels,
+	})
+	return err
+}
+
+// Responds with ranking warnings of the imported sections so that
+// uploaders notice results that do not match the entry order.
+func write_import_ok(w http.ResponseWriter, sections []*base.Section) {
+	w.Write([]byte("OK\n"))
+	for _, section := range sections {
+		for _, warning := range state.RankingWarnings(section) {
+			w.Write([]byte(
+				fmt.Sprintf("Warning: %s: %s\n", section.Key, warning)))
+		}
+	}
+}
+
 func start_upload_session() {
 
 }
@@ -175,6 +199,10 @@ func handle_year(
 		bad_request(w, "Invalid tar file: "+err_extract.Error())
 		return
 	}
+	if err := derive_images(settings, new_dir); err != nil {
+		bad_request(w, "Invalid image data: "+err.Error())
+		return
+	}
 
 	year_data, err_read := state.ReadYear(
 		new_dir,
@@ -190,6 +218,10 @@ func handle_year(
 			w, fmt.Sprintf("Year data for year %d is out of range", year))
 		return
 	}
+	if err := state.VerifyYearFiles(year_data); err != nil {
+		bad_request(w, "Invalid year data: "+err.Error())
+		return
+	}
 
 	target_dir := filepath.Join(settings.DataDir, strconv.Itoa(year))
 	old_dir := filepath.Join(tmpdir, "old")
@@ -198,34 +230,8 @@ func handle_year(
 		_ise(w, err_replace)
 		return
 	}
-	year_added := false
-	var mod_years []*base.Year
-	var next_year *base.Year
-	for _, prev_year := range site_state.Years {
-		if prev_year.Year == year {
-			mod_years = append(mod_years, year_data)
-			next_year = year_data
-			year_added = true
-			continue
-		}
-		if next_year != nil && prev_year.Year < year && year < next_year.Year {
-			mod_years = append(mod_years, year_data)
-			year_added = true
-		}
-		next_year = prev_year
-		mod_years = append(mod_years, prev_year)
-	}
-	if !year_added {
-		if next_year == nil {
-			mod_years = []*base.Year{year_data}
-		} else if year < next_year.Year {
-			mod_years = append(mod_years, year_data)
-		} else {
-			mod_years = append([]*base.Year{year_data}, mod_years...)
-		}
-	}
-	site_state.Years = mod_years
-	w.Write([]byte("OK\n"))
+	site_state.ReplaceYear(year_data)
+	write_import_ok(w, year_data.Sections)
 }
 
 func handle_section(
@@ -242,6 +248,10 @@ func handle_section(
 		bad_request(w, "Can only update an existing section")
 		return
 	}
+	if !site_state.HasSection(year.Key, key) {
+		bad_request(w, "Can only update a section that the year lists")
+		return
+	}
 	var tmpdir string
 	{
 		_tmpdir, err := ioutil.TempDir(settings.DataDir, ".api.new-section-")
@@ -259,6 +269,10 @@ func handle_section(
 		bad_request(w, "Invalid tar file: "+err_extract.Error())
 		return
 	}
+	if err := derive_images(settings, new_dir); err != nil {
+		bad_request(w, "Invalid image data: "+err.Error())
+		return
+	}
 	section_data, err_section := state.ReadSection(
 		new_dir,
 		fmt.Sprintf("%s/_data/%s/%s", settings.SiteRoot, year.Key, key),
@@ -268,6 +282,10 @@ func handle_section(
 		bad_request(w, "Invalid section data: "+err_section.Error())
 		return
 	}
+	if err := state.VerifySectionFiles(section_data); err != nil {
+		bad_request(w, "Invalid section data: "+err.Error())
+		return
+	}
 
 	old_dir := filepath.Join(tmpdir, "old")
 	err_replace := replace_path(target_dir, new_dir, old_dir)
@@ -275,22 +293,80 @@ func handle_section(
 		_ise(w, err_replace)
 		return
 	}
-	for i, old_section := range year.Sections {
-		if old_section.Key == key {
-			year.Sections[i] = section_data
-			break
+	err_state := site_state.ReplaceSection(year.Key, section_data)
+	if err_state != nil {
+		// Disk is kept in sync with the state that is served.
+		err_restore := replace_path(
+			target_dir, old_dir, filepath.Join(tmpdir, "rejected"))
+		if err_restore != nil {
+			log.Printf("Unable to restore %s: %s", target_dir, err_restore)
 		}
+		_ise(w, err_state)
+		return
+	}
+	write_import_ok(w, []*base.Section{section_data})
+}
+
+// Reloads a year or a section from the data directory. This is
+// meant for cases where data has been updated by some other means
+// than this API, like by copying files by hand.
+func handle_reload(
+	site_state *state.SiteState,
+	year string,
+	section string,
+	w http.ResponseWriter) {
+	var err_reload error
+	if section == "" {
+		err_reload = site_state.UpdateYear(year)
+	} else {
+		err_reload = site_state.UpdateSection(year, section)
+	}
+	if err_reload != nil {
+		bad_request(w, "Unable to reload data: "+err_reload.Error())
+		return
 	}
 	w.Write([]byte("OK\n"))
 }
 
+// Lists years and sections that failed to load and are left out of
+// the site until they are replaced or reloaded.
+func handle_status(
+	site_state *state.SiteState,
+	w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method Not Allowed.\n"))
+		return
+	}
+	load_errors := site_state.LoadErrors()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Years: %d\n", len(site_state.Years()))
+	fmt.Fprintf(w, "Load errors: %d\n", len(load_errors))
+	for _, load_error := range load_errors {
+		name := load_error.Year
+		if load_error.Section != "" {
+			name += "/" + load_error.Section
+		}
+		fmt.Fprintf(
+			w, "%s (%s): %s\n", name, load_error.Path, load_error.Message)
+	}
+}
+
 func renderer(
 	state *ApiState,
 	w http.ResponseWriter,
 	r *http.Request) {
+	if r.URL.Path == "status" {
+		handle_status(state.SiteState, w, r)
+		return
+	}
 	switch r.Method {
 	case http.MethodPut:
-		// Only accept PUT method.
+		// PUT method replaces data with the uploaded tarball.
+	case http.MethodPost:
+		// POST method reloads data that already is in the data
+		// directory.
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Write([]byte("Method Not Allowed.\n"))
@@ -311,17 +387,15 @@ func renderer(
 		return
 	}
 	year_int, _ := strconv.Atoi(year_str)
+	if len(parts) == 1 && r.Method == http.MethodPost {
+		handle_reload(state.SiteState, year_str, "", w)
+		return
+	}
 	if len(parts) == 1 {
 		handle_year(state.Settings, state.SiteState, year_int, w, r)
 		return
 	}
-	var year *base.Year
-	for _, year_candidate := range state.SiteState.Years {
-		if year_candidate.Key == year_str {
-			year = year_candidate
-			break
-		}
-	}
+	year := state.SiteState.GetYear(year_str)
 	if year == nil {
 		bad_request(w, "No previous year defined for section!")
 		return
@@ -336,6 +410,10 @@ func renderer(
 		bad_request(w, "Illegal section name '"+section+"'!")
 		return
 	}
+	if r.Method == http.MethodPost {
+		handle_reload(state.SiteState, year_str, section, w)
+		return
+	}
 	handle_section(state.Settings, state.SiteState, year, section, w, r)
 }
 
@@ -0,0 +1,208 @@
+package assets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type AudioInfo struct {
+	Path     string
+	FsPath   string
+	Checksum string
+	Type     string
+	// Duration in seconds. Zero means unknown duration, like with
+	// some tracker modules.
+	Duration int
+}
+
+// Audio files that are stored in the entry directory. Sources
+// include both files that can be played in a browser and files that
+// can only be downloaded, like tracker modules.
+type AudioAsset struct {
+	Sources []AudioInfo
+}
+
+type AudioInfoMeta struct {
+	Filename string
+	Type     string
+	Checksum string
+	Duration int
+}
+
+type AudioMeta struct {
+	Sources []AudioInfoMeta
+}
+
+// Audio types that browsers can play. Other types, like tracker
+// modules, are only offered as downloads.
+var PLAYABLE_AUDIO_TYPES = map[string]bool{
+	"audio/flac": true,
+	"audio/mp4":  true,
+	"audio/mpeg": true,
+	"audio/ogg":  true,
+	"audio/opus": true,
+	"audio/wav":  true,
+	"audio/webm": true,
+}
+
+func validate_audio_info_meta(info AudioInfoMeta) error {
+	if len(info.Filename) < len("a.xm") {
+		return fmt.Errorf(
+			"Audio file name '%s' is too short to be a valid one",
+			info.Filename)
+	}
+	if !strings.HasPrefix(info.Type, "audio/") {
+		return fmt.Errorf(
+			"Audio %s type '%s' is not an audio type",
+			info.Filename,
+			info.Type)
+	}
+	if len(info.Checksum) < 6 {
+		return fmt.Errorf(
+			"Audio %s checksum '%s' does not contain enough entropy",
+			info.Filename,
+			info.Checksum)
+	}
+	if info.Duration < 0 {
+		return fmt.Errorf(
+			"Audio %s duration %d is negative!",
+			info.Filename,
+			info.Duration)
+	}
+	return nil
+}
+
+func view_duration(seconds int) string {
+	if seconds >= 3600 {
+		return fmt.Sprintf(
+			"%d:%02d:%02d", seconds/3600, (seconds%3600)/60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+type audio_type struct{}
+
+func (audio_type) Name() string {
+	return "audio"
+}
+
+func (audio_type) DefaultTitle() string {
+	return "Audio"
+}
+
+func (audio_type) Decode(data []byte) (interface{}, error) {
+	var meta AudioMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (audio_type) Validate(meta interface{}) error {
+	audio := meta.(AudioMeta)
+	if len(audio.Sources) == 0 {
+		return fmt.Errorf("Audio asset does not have any sources")
+	}
+	for _, source := range audio.Sources {
+		if err := validate_audio_info_meta(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (audio_type) VerifyFiles(
+	meta interface{},
+	verify func(filename string, checksum string) error) error {
+	for _, source := range meta.(AudioMeta).Sources {
+		if err := verify(source.Filename, source.Checksum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (audio_type) Locate(
+	meta interface{}, data_path string, fs_directory string) interface{} {
+	audio := meta.(AudioMeta)
+	result := AudioAsset{}
+	for _, source := range audio.Sources {
+		result.Sources = append(result.Sources, AudioInfo{
+			Path:     locate_path(data_path, source.Filename),
+			FsPath:   filepath.Join(fs_directory, source.Filename),
+			Checksum: source.Checksum,
+			Type:     source.Type,
+			Duration: source.Duration,
+		})
+	}
+	return result
+}
+
+func (audio_type) RegisterGob() {
+	register_gob("state.AudioAsset", AudioAsset{})
+}
+
+func (audio_type) Render(context RenderContext, data interface{}) string {
+	audio := data.(AudioAsset)
+	var player bytes.Buffer
+	var downloads bytes.Buffer
+	for _, source := range audio.Sources {
+		source_path := html.EscapeString(fmt.Sprintf(
+			"%s?%s",
+			strings.Replace(source.Path, " ", "%20", -1),
+			source.Checksum))
+		if PLAYABLE_AUDIO_TYPES[source.Type] {
+			player.WriteString(fmt.Sprintf(
+				"<source src='%s' type='%s' />",
+				source_path,
+				html.EscapeString(source.Type)))
+		}
+		duration := ""
+		if source.Duration > 0 {
+			duration = " " + view_duration(source.Duration)
+		}
+		downloads.WriteString(fmt.Sprintf(
+			"<li><a href=\"%s\" download>%s</a>%s</li>",
+			source_path,
+			html.EscapeString(path.Base(source.Path)),
+			duration))
+	}
+	result := ""
+	if player.Len() > 0 {
+		result += fmt.Sprintf(
+			"<audio controls=\"controls\" preload=\"metadata\">%s</audio>\n",
+			player.String())
+	}
+	result += fmt.Sprintf(
+		"<ul class=\"audio-downloads\">%s</ul>\n", downloads.String())
+	return result
+}
+
+func (audio_type) EncodeJson(data interface{}) interface{} {
+	type AudioJson struct {
+		Url      string `json:"url"`
+		Type     string `json:"type"`
+		Duration int    `json:"duration"`
+	}
+	audio := data.(AudioAsset)
+	sources := make([]AudioJson, len(audio.Sources))
+	for index, source := range audio.Sources {
+		sources[index] = AudioJson{
+			Url:      FileUrl(source.Path, source.Checksum),
+			Type:     source.Type,
+			Duration: source.Duration,
+		}
+	}
+	return struct {
+		Sources []AudioJson `json:"sources"`
+	}{sources}
+}
+
+func init() {
+	Register(audio_type{})
+}
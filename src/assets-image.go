@@ -0,0 +1,128 @@
+package assets
+
+import (
+	"base"
+	"encoding/json"
+	"fmt"
+	"html"
+)
+
+type ImageAsset struct {
+	Default base.ImageInfo
+	Sources []base.ImageInfo
+}
+
+type ImageMeta struct {
+	Default ImageInfoMeta   `json:"default"`
+	Sources []ImageInfoMeta `json:"sources"`
+}
+
+type image_type struct{}
+
+func (image_type) Name() string {
+	return "image"
+}
+
+func (image_type) DefaultTitle() string {
+	return "Image"
+}
+
+func (image_type) Decode(data []byte) (interface{}, error) {
+	var meta ImageMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (image_type) Validate(meta interface{}) error {
+	image := meta.(ImageMeta)
+	if err := ValidateImageInfoMeta(image.Default); err != nil {
+		return err
+	}
+	for _, source := range image.Sources {
+		if err := ValidateImageInfoMeta(source); err != nil {
+			return fmt.Errorf(
+				"Source image error %s: %v", image.Default.Filename, err)
+		}
+	}
+	return nil
+}
+
+func (image_type) UpdateImages(
+	meta interface{},
+	update func(info *ImageInfoMeta) error) (interface{}, error) {
+	image := meta.(ImageMeta)
+	if err := update(&image.Default); err != nil {
+		return nil, err
+	}
+	sources := make([]ImageInfoMeta, len(image.Sources))
+	copy(sources, image.Sources)
+	for index := range sources {
+		if err := update(&sources[index]); err != nil {
+			return nil, err
+		}
+	}
+	image.Sources = sources
+	return image, nil
+}
+
+func (image_type) Images(data interface{}) []base.ImageInfo {
+	image := data.(ImageAsset)
+	return append([]base.ImageInfo{image.Default}, image.Sources...)
+}
+
+func (image_type) Locate(
+	meta interface{}, data_path string, fs_directory string) interface{} {
+	image := meta.(ImageMeta)
+	sources := make([]base.ImageInfo, len(image.Sources))
+	for index, source := range image.Sources {
+		sources[index] = LocateImage(data_path, fs_directory, source)
+	}
+	return ImageAsset{
+		Default: LocateImage(data_path, fs_directory, image.Default),
+		Sources: sources,
+	}
+}
+
+func (image_type) RegisterGob() {
+	register_gob("state.ImageAsset", ImageAsset{})
+}
+
+func (image_type) Render(context RenderContext, data interface{}) string {
+	image := data.(ImageAsset)
+	EMBED_TEMPLATE := `
+<picture>
+    %s
+    <img src="%s" alt="%s" title="%s" width="%d" height="%d" />
+</picture>
+`
+	image_path := fmt.Sprintf(
+		"%s?%s",
+		image.Default.Path,
+		image.Default.Checksum)
+	return fmt.Sprintf(
+		EMBED_TEMPLATE,
+		ImageSrcset(image.Sources),
+		html.EscapeString(image_path),
+		html.EscapeString(context.AuthorTitle),
+		html.EscapeString(context.AuthorTitle),
+		image.Default.Size.X,
+		image.Default.Size.Y,
+	)
+}
+
+func (image_type) EncodeJson(data interface{}) interface{} {
+	image := data.(ImageAsset)
+	return struct {
+		Default ImageJson   `json:"default"`
+		Sources []ImageJson `json:"sources"`
+	}{
+		Default: EncodeImageJson(image.Default),
+		Sources: EncodeImagesJson(image.Sources),
+	}
+}
+
+func init() {
+	Register(image_type{})
+}
@@ -0,0 +1,201 @@
+package assets
+
+import (
+	"base"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Video files that are stored in the entry directory. Sources are
+// listed in the order of preference.
+type VideoAsset struct {
+	Poster  base.ImageInfo
+	Sources []base.ImageInfo
+}
+
+type VideoMeta struct {
+	Poster  ImageInfoMeta
+	Sources []ImageInfoMeta
+}
+
+func validate_video_info_meta(info ImageInfoMeta) error {
+	if len(info.Filename) < len("a.mp4") {
+		return fmt.Errorf(
+			"Video file name '%s' is too short to be a valid one",
+			info.Filename)
+	}
+	if !strings.HasPrefix(info.Type, "video/") {
+		return fmt.Errorf(
+			"Video %s type '%s' is not a video type",
+			info.Filename,
+			info.Type)
+	}
+	if len(info.Checksum) < 6 {
+		return fmt.Errorf(
+			"Video %s checksum '%s' does not contain enough entropy",
+			info.Filename,
+			info.Checksum)
+	}
+	if info.Size.X < 16 || info.Size.Y < 16 {
+		return fmt.Errorf(
+			"Video %s size %dx%d is too small!",
+			info.Filename,
+			info.Size.X,
+			info.Size.Y)
+	}
+	return nil
+}
+
+func view_video_sources(videos []base.ImageInfo) string {
+	var result bytes.Buffer
+	for _, video := range videos {
+		result.WriteString(fmt.Sprintf(
+			"<source src='%s?%s' type='%s' />",
+			html.EscapeString(strings.Replace(video.Path, " ", "%20", -1)),
+			html.EscapeString(video.Checksum),
+			html.EscapeString(video.Type),
+		))
+	}
+	return result.String()
+}
+
+type video_type struct{}
+
+func (video_type) Name() string {
+	return "video"
+}
+
+func (video_type) DefaultTitle() string {
+	return "Video"
+}
+
+func (video_type) Decode(data []byte) (interface{}, error) {
+	var meta VideoMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (video_type) Validate(meta interface{}) error {
+	video := meta.(VideoMeta)
+	if len(video.Sources) == 0 {
+		return fmt.Errorf("Video asset does not have any sources")
+	}
+	if video.Poster.Filename != "" {
+		if err := ValidateImageInfoMeta(video.Poster); err != nil {
+			return fmt.Errorf("Video poster error: %v", err)
+		}
+	}
+	for _, source := range video.Sources {
+		if err := validate_video_info_meta(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Only the poster is an image, video files are not decoded.
+func (video_type) UpdateImages(
+	meta interface{},
+	update func(info *ImageInfoMeta) error) (interface{}, error) {
+	video := meta.(VideoMeta)
+	if video.Poster.Filename == "" {
+		return video, nil
+	}
+	if err := update(&video.Poster); err != nil {
+		return nil, err
+	}
+	return video, nil
+}
+
+func (video_type) VerifyFiles(
+	meta interface{},
+	verify func(filename string, checksum string) error) error {
+	for _, source := range meta.(VideoMeta).Sources {
+		if err := verify(source.Filename, source.Checksum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (video_type) Images(data interface{}) []base.ImageInfo {
+	video := data.(VideoAsset)
+	if video.Poster.FsPath == "" {
+		return nil
+	}
+	return []base.ImageInfo{video.Poster}
+}
+
+func (video_type) Locate(
+	meta interface{}, data_path string, fs_directory string) interface{} {
+	video := meta.(VideoMeta)
+	result := VideoAsset{}
+	if video.Poster.Filename != "" {
+		result.Poster = LocateImage(data_path, fs_directory, video.Poster)
+	}
+	for _, source := range video.Sources {
+		result.Sources = append(
+			result.Sources, LocateImage(data_path, fs_directory, source))
+	}
+	return result
+}
+
+func (video_type) RegisterGob() {
+	register_gob("state.VideoAsset", VideoAsset{})
+}
+
+func (video_type) Render(context RenderContext, data interface{}) string {
+	video := data.(VideoAsset)
+	EMBED_TEMPLATE := `
+<video controls="controls" preload="metadata" %s width="%d" height="%d">
+    %s
+    <a href="%s">%s</a>
+</video>
+`
+	DEFAULT_WIDTH := 640
+	width := DEFAULT_WIDTH
+	preferred := video.Sources[0]
+	height := width * preferred.Size.Y / preferred.Size.X
+	poster := ""
+	if video.Poster.Path != "" {
+		poster = fmt.Sprintf(
+			"poster=\"%s\"",
+			html.EscapeString(fmt.Sprintf(
+				"%s?%s", video.Poster.Path, video.Poster.Checksum)))
+	}
+	download_path := fmt.Sprintf("%s?%s", preferred.Path, preferred.Checksum)
+	return fmt.Sprintf(
+		EMBED_TEMPLATE,
+		poster,
+		width,
+		height,
+		view_video_sources(video.Sources),
+		html.EscapeString(download_path),
+		html.EscapeString(context.AuthorTitle),
+	)
+}
+
+func (video_type) EncodeJson(data interface{}) interface{} {
+	video := data.(VideoAsset)
+	var poster *ImageJson
+	if video.Poster.Path != "" {
+		poster_json := EncodeImageJson(video.Poster)
+		poster = &poster_json
+	}
+	return struct {
+		Poster  *ImageJson  `json:"poster,omitempty"`
+		Sources []ImageJson `json:"sources"`
+	}{
+		Poster:  poster,
+		Sources: EncodeImagesJson(video.Sources),
+	}
+}
+
+func init() {
+	Register(video_type{})
+}
@@ -0,0 +1,98 @@
+package assets
+
+import (
+	"base"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+type VimeoAsset struct {
+	Id string
+	// Entries without their own thumbnails can opt in to use the
+	// thumbnail of the Vimeo video from a third party service.
+	RemoteThumbnail bool `json:"remote-thumbnail"`
+}
+
+// Vimeo video IDs are numeric. Start time can be given in the same
+// fashion as with YouTube videos, for example 1234567#t=1m30s.
+var VIMEO_ID = regexp.MustCompile("^[0-9]+(#t=[0-9hms]+)?$")
+
+type vimeo_type struct{}
+
+func (vimeo_type) Name() string {
+	return "vimeo"
+}
+
+func (vimeo_type) DefaultTitle() string {
+	return "Video"
+}
+
+func (vimeo_type) Decode(data []byte) (interface{}, error) {
+	var meta VimeoAsset
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (vimeo_type) Validate(meta interface{}) error {
+	vimeo := meta.(VimeoAsset)
+	if !VIMEO_ID.MatchString(vimeo.Id) {
+		return fmt.Errorf("Vimeo video ID '%s' is not valid", vimeo.Id)
+	}
+	return nil
+}
+
+func (vimeo_type) Locate(
+	meta interface{}, data_path string, fs_directory string) interface{} {
+	return meta
+}
+
+func (vimeo_type) RegisterGob() {
+	register_gob("state.VimeoAsset", VimeoAsset{})
+}
+
+// Vimeo entries that do not include their own thumbnails and that
+// have opted in with "remote-thumbnail" use the thumbnail of the Vimeo
+// video. Vimeo does not provide thumbnails by video ID without API
+// access, so these are fetched through vumbnail.com that does the
+// lookup. Other entries need local thumbnails like all other assets.
+func (vimeo_type) Thumbnail(data interface{}) (base.ImageInfo, bool) {
+	vimeo := data.(VimeoAsset)
+	if !vimeo.RemoteThumbnail {
+		return base.ImageInfo{}, false
+	}
+	id := strings.SplitN(vimeo.Id, "#", 2)[0]
+	return base.ImageInfo{
+		Path: fmt.Sprintf("https://vumbnail.com/%s.jpg", id),
+		Type: "image/jpeg",
+		Size: base.Resolution{X: 640, Y: 360},
+	}, true
+}
+
+func (vimeo_type) Render(context RenderContext, data interface{}) string {
+	vimeo := data.(VimeoAsset)
+	EMBED_TEMPLATE := `<iframe id="vimeoplayerembed" class="vimeo-player" width="%d" height="%d" src="https://player.vimeo.com/video/%s" style="border: 0px" allow="autoplay; fullscreen" allowfullscreen="allowfullscreen">
+</iframe>`
+	ASPECT_RATIO := 16.0 / 9.0
+	DEFAULT_WIDTH := 640
+	width := DEFAULT_WIDTH
+	height := int(float64(width) / ASPECT_RATIO)
+	// Vimeo player understands the same #t=1m30s start time fragment
+	// that is used in the video ID.
+	return fmt.Sprintf(
+		EMBED_TEMPLATE, width, height, html.EscapeString(vimeo.Id))
+}
+
+func (vimeo_type) EncodeJson(data interface{}) interface{} {
+	return struct {
+		Id string `json:"id"`
+	}{data.(VimeoAsset).Id}
+}
+
+func init() {
+	Register(vimeo_type{})
+}
@@ -0,0 +1,71 @@
+package assets
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+)
+
+type YoutubeAsset struct {
+	Id string
+}
+
+type youtube_type struct{}
+
+func (youtube_type) Name() string {
+	return "youtube"
+}
+
+func (youtube_type) DefaultTitle() string {
+	return "Video"
+}
+
+func (youtube_type) Decode(data []byte) (interface{}, error) {
+	var meta YoutubeAsset
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (youtube_type) Validate(meta interface{}) error {
+	return nil
+}
+
+func (youtube_type) Locate(
+	meta interface{}, data_path string, fs_directory string) interface{} {
+	return meta
+}
+
+func (youtube_type) RegisterGob() {
+	register_gob("state.YoutubeAsset", YoutubeAsset{})
+}
+
+func (youtube_type) Render(context RenderContext, data interface{}) string {
+	youtube := data.(YoutubeAsset)
+	EMBED_TEMPLATE := `<iframe id="ytplayerembed" class="youtube-player" width="%d" height="%d" src="https://www.youtube.com/embed/%s" style="border: 0px" allowfullscreen="allowfullscreen">\n</iframe>`
+	CONTROLS_HEIGHT := 0.0
+	ASPECT_RATIO := 16.0 / 9.0
+	DEFAULT_WIDTH := 640
+	width := DEFAULT_WIDTH
+	height := int(float64(width)/ASPECT_RATIO + CONTROLS_HEIGHT)
+	embed_id := youtube.Id
+	if strings.Contains(youtube.Id, "#t=") {
+		splits := strings.SplitN(youtube.Id, "#t=", 2)
+		id := splits[0]
+		timestamp := splits[1]
+		embed_id = fmt.Sprintf("%s?start=%s", id, timestamp)
+	}
+	return fmt.Sprintf(EMBED_TEMPLATE, width, height, html.EscapeString(embed_id))
+}
+
+func (youtube_type) EncodeJson(data interface{}) interface{} {
+	return struct {
+		Id string `json:"id"`
+	}{data.(YoutubeAsset).Id}
+}
+
+func init() {
+	Register(youtube_type{})
+}
@@ -0,0 +1,287 @@
+package assets
+
+import (
+	"base"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"html"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Information about the entry that an asset is rendered for.
+type RenderContext struct {
+	Entry base.Entry
+	// Entry title in "Title by Author" format.
+	AuthorTitle string
+}
+
+// Everything that is needed to support one type of entry assets. Asset
+// types are registered with Register() and each type is identified by
+// the "type" field of the asset in entry metadata.
+type AssetType interface {
+	// Value of the "type" field in entry metadata.
+	Name() string
+	// Title that is shown in asset navigation when the asset does not
+	// have its own title.
+	DefaultTitle() string
+	// Decodes the "data" field of an asset into type specific
+	// metadata.
+	Decode(data []byte) (interface{}, error)
+	// Validates metadata returned by Decode().
+	Validate(meta interface{}) error
+	// Converts validated metadata into asset data where file names
+	// are made to refer to files in the entry directory.
+	Locate(meta interface{}, data_path string, fs_directory string) interface{}
+	// Registers asset data types that are stored in aggregate
+	// metadata caches.
+	RegisterGob()
+	// Renders asset data returned by Locate() into HTML.
+	Render(context RenderContext, data interface{}) string
+}
+
+// Asset types that can provide a thumbnail for entries that do not
+// include their own thumbnails.
+type ThumbnailProvider interface {
+	Thumbnail(data interface{}) (base.ImageInfo, bool)
+}
+
+// Asset types that can describe their data in the public JSON API.
+// Returned values are encoded with encoding/json and must not reveal
+// file system paths. Data of other asset types is left out.
+type JsonEncoder interface {
+	EncodeJson(data interface{}) interface{}
+}
+
+// Asset types that refer to image files in the entry directory. Image
+// information of their metadata is checked against the files before
+// the metadata is validated.
+type ImageUpdater interface {
+	// Calls update for each image of decoded metadata and returns the
+	// metadata with the updated image information.
+	UpdateImages(
+		meta interface{},
+		update func(info *ImageInfoMeta) error) (interface{}, error)
+	// Returns the images of located asset data, so that imported
+	// files can be verified.
+	Images(data interface{}) []base.ImageInfo
+}
+
+// Asset types that refer to other files in the entry directory, like
+// videos and audio. Their files are checked when data directories are
+// validated.
+type FileVerifier interface {
+	// Calls verify with the file name and the checksum of each file of
+	// decoded metadata and returns the first error.
+	VerifyFiles(
+		meta interface{},
+		verify func(filename string, checksum string) error) error
+}
+
+var asset_types = map[string]AssetType{}
+
+func Register(asset_type AssetType) {
+	if _, ok := asset_types[asset_type.Name()]; ok {
+		panic(fmt.Sprintf(
+			"Asset type %s is already registered", asset_type.Name()))
+	}
+	asset_types[asset_type.Name()] = asset_type
+}
+
+func Get(name string) (AssetType, bool) {
+	asset_type, ok := asset_types[name]
+	return asset_type, ok
+}
+
+// Returns names of all registered asset types in alphabetical order.
+func Names() []string {
+	var names []string
+	for name := range asset_types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var register_gob_once sync.Once
+
+func RegisterGob() {
+	register_gob_once.Do(func() {
+		for _, name := range Names() {
+			asset_types[name].RegisterGob()
+		}
+	})
+}
+
+// Asset data types that are registered for gob encoding.
+var gob_types = map[string]reflect.Type{}
+
+// Returns registered asset data types in the order of their names, so
+// that aggregate metadata caches can tell when they have changed.
+func GobTypes() []reflect.Type {
+	RegisterGob()
+	var names []string
+	for name := range gob_types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var result []reflect.Type
+	for _, name := range names {
+		result = append(result, gob_types[name])
+	}
+	return result
+}
+
+type ImageInfoMeta struct {
+	Filename string          `json:"filename"`
+	Type     string          `json:"type"`
+	Checksum string          `json:"checksum"`
+	Size     base.Resolution `json:"size"`
+}
+
+func ValidateImageInfoMeta(info ImageInfoMeta) error {
+	if len(info.Filename) < len("a.png") {
+		return fmt.Errorf(
+			"Image file name '%s' is too short to be a valid one",
+			info.Filename)
+	}
+	if len(info.Type) < len("image/png") {
+		return fmt.Errorf(
+			"Image %s type '%s' is too short to be a valid one",
+			info.Filename,
+			info.Type)
+	}
+	if len(info.Checksum) < 6 {
+		return fmt.Errorf(
+			"Image %s checksum '%s' does not contain enough entropy",
+			info.Filename,
+			info.Checksum)
+	}
+	if info.Size.X < 16 || info.Size.Y < 16 {
+		return fmt.Errorf(
+			"Image %s size %dx%d is too small!",
+			info.Filename,
+			info.Size.X,
+			info.Size.Y)
+	}
+	return nil
+}
+
+// Creates image information where the file name refers to a file in
+// the given entry directory.
+func LocateImage(
+	data_path string,
+	fs_directory string,
+	meta ImageInfoMeta) base.ImageInfo {
+	return base.ImageInfo{
+		Path:     locate_path(data_path, meta.Filename),
+		FsPath:   filepath.Join(fs_directory, meta.Filename),
+		Checksum: meta.Checksum,
+		Size:     meta.Size,
+		Type:     meta.Type,
+	}
+}
+
+func locate_path(data_path string, filename string) string {
+	return path.Clean(fmt.Sprintf("%s/%s", data_path, filename))
+}
+
+// Image or a video file as it is described in the public JSON API.
+type ImageJson struct {
+	Url    string `json:"url"`
+	Type   string `json:"type"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Creates the same checksummed URL that site templates use. Files
+// without a checksum, like external thumbnails, are used as is.
+func FileUrl(file_path string, checksum string) string {
+	url := strings.Replace(file_path, " ", "%20", -1)
+	if checksum == "" {
+		return url
+	}
+	return url + "?" + checksum
+}
+
+func EncodeImageJson(image base.ImageInfo) ImageJson {
+	return ImageJson{
+		Url:    FileUrl(image.Path, image.Checksum),
+		Type:   image.Type,
+		Width:  image.Size.X,
+		Height: image.Size.Y,
+	}
+}
+
+func EncodeImagesJson(images []base.ImageInfo) []ImageJson {
+	result := make([]ImageJson, len(images))
+	for index, image := range images {
+		result[index] = EncodeImageJson(image)
+	}
+	return result
+}
+
+func ImageSrcset(images []base.ImageInfo) string {
+	type SrcSet struct {
+		Srcs  bytes.Buffer
+		Sizes bytes.Buffer
+	}
+	sets := map[string]*SrcSet{}
+	var last_set *SrcSet = nil
+	last_type := ""
+	for _, image := range images {
+		srcset := last_set
+		if image.Type != last_type {
+			var ok bool
+			srcset, ok = sets[image.Type]
+			if !ok {
+				srcset = &SrcSet{}
+				sets[image.Type] = srcset
+				srcset.Srcs.Grow(768)
+				srcset.Sizes.Grow(64)
+			} else {
+				srcset.Srcs.WriteString(", ")
+				srcset.Sizes.WriteString(", ")
+			}
+			last_set = srcset
+			last_type = image.Type
+		} else {
+			srcset.Srcs.WriteString(", ")
+			srcset.Sizes.WriteString(", ")
+		}
+		srcset.Srcs.WriteString(
+			html.EscapeString(strings.Replace(image.Path, " ", "%20", -1)))
+		srcset.Srcs.Write([]byte("?"))
+		srcset.Srcs.WriteString(html.EscapeString(image.Checksum))
+		srcset.Srcs.Write([]byte(" "))
+		size_x_str := []byte(strconv.Itoa(image.Size.X))
+		srcset.Srcs.Write(size_x_str)
+		srcset.Srcs.Write([]byte("w"))
+		srcset.Sizes.Write(size_x_str)
+		srcset.Sizes.Write([]byte("px"))
+	}
+	result := ""
+	for set_type, set_value := range sets {
+		result += fmt.Sprintf(
+			"<source type='%s' srcset='%s' sizes='%s' />",
+			set_type,
+			set_value.Srcs.String(),
+			set_value.Sizes.String(),
+		)
+	}
+	return result
+}
+
+// Registers asset data for gob encoding under the given name. Asset
+// types that were defined in the state package before keep their
+// state.* names, so that existing metadata caches stay readable.
+func register_gob(name string, value interface{}) {
+	gob.RegisterName(name, value)
+	gob_types[name] = reflect.TypeOf(value)
+}
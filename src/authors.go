@@ -0,0 +1,245 @@
+package authors
+
+import (
+	"base"
+	"regexp"
+	"search"
+	"strings"
+)
+
+// Entries made together by several authors list them separated by
+// commas or ampersands, like "Purple Motion & Skaven".
+var AUTHOR_SEPARATOR = regexp.MustCompile(`\s*[,&]\s*`)
+
+type Section struct {
+	Section *base.Section
+	Entries []*base.Entry
+}
+
+type Year struct {
+	Year     *base.Year
+	Sections []Section
+}
+
+// All entries of a single author grouped by year and section in the
+// archive order. Authors that are only known from plain author texts
+// are neither groups nor persons.
+type Author struct {
+	Key string
+	// Name as it was written in the latest entry.
+	Name       string
+	IsGroup    bool
+	Aliases    []string
+	Years      []Year
+	EntryCount int
+	// Groups that a person has been credited as a member of and
+	// members that a group has had, in the order of appearance.
+	Groups  []*Author
+	Members []*Author
+	// Author pages are named after the canonical name even when the
+	// latest entry uses an alias.
+	has_canonical_name bool
+}
+
+type Index struct {
+	authors map[string]*Author
+	// Alias keys to the keys of the canonical names.
+	aliases map[string]string
+}
+
+// Splits an author field of an entry into individual author names.
+func SplitNames(author string) []string {
+	var result []string
+	for _, name := range AUTHOR_SEPARATOR.Split(author, -1) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		result = append(result, name)
+	}
+	return result
+}
+
+// Returns the normalised key of an author name that is used in author
+// page addresses. Different spellings of the same handle, like "Jml^vs"
+// and "JML vs", result in the same key. Names without any latin
+// letters or digits do not have a key.
+func Key(name string) string {
+	return search.Slug(name)
+}
+
+func (author *Author) add_entry(
+	year *base.Year, section *base.Section, entry *base.Entry) {
+	author.EntryCount++
+	last_year := len(author.Years) - 1
+	if last_year < 0 || author.Years[last_year].Year != year {
+		author.Years = append(author.Years, Year{Year: year})
+		last_year++
+	}
+	author_year := &author.Years[last_year]
+	last_section := len(author_year.Sections) - 1
+	if last_section < 0 || author_year.Sections[last_section].Section != section {
+		author_year.Sections = append(
+			author_year.Sections, Section{Section: section})
+		last_section++
+	}
+	author_section := &author_year.Sections[last_section]
+	// Same author can be listed multiple times in one entry with
+	// different spellings.
+	last_entry := len(author_section.Entries) - 1
+	if last_entry >= 0 && author_section.Entries[last_entry] == entry {
+		author.EntryCount--
+		return
+	}
+	author_section.Entries = append(author_section.Entries, entry)
+}
+
+func add_related(authors []*Author, author *Author) []*Author {
+	for _, existing := range authors {
+		if existing == author {
+			return authors
+		}
+	}
+	return append(authors, author)
+}
+
+func (index *Index) add_aliases(credit base.Credit) {
+	key := Key(credit.Name)
+	for _, alias := range credit.Aliases {
+		alias_key := Key(alias)
+		if alias_key == "" || alias_key == key {
+			continue
+		}
+		// First credited alias wins if the same handle has been
+		// used by several authors.
+		if _, ok := index.aliases[alias_key]; !ok {
+			index.aliases[alias_key] = key
+		}
+	}
+	for _, member := range credit.Members {
+		index.add_aliases(member)
+	}
+}
+
+func (index *Index) get_author(name string) *Author {
+	key := Key(name)
+	if key == "" {
+		return nil
+	}
+	canonical, is_alias := index.aliases[key]
+	if is_alias {
+		key = canonical
+	}
+	author, ok := index.authors[key]
+	if !ok {
+		author = &Author{Key: key, Name: name}
+		index.authors[key] = author
+	}
+	if !is_alias && !author.has_canonical_name {
+		author.Name = name
+		author.has_canonical_name = true
+	}
+	return author
+}
+
+func (index *Index) add_credit(
+	year *base.Year,
+	section *base.Section,
+	entry *base.Entry,
+	credit base.Credit,
+	is_group bool) *Author {
+	author := index.get_author(credit.Name)
+	if author == nil {
+		return nil
+	}
+	if is_group {
+		author.IsGroup = true
+	}
+	for _, alias := range credit.Aliases {
+		if Key(alias) != author.Key && !contains(author.Aliases, alias) {
+			author.Aliases = append(author.Aliases, alias)
+		}
+	}
+	author.add_entry(year, section, entry)
+	return author
+}
+
+func contains(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (index *Index) add_entry(
+	year *base.Year, section *base.Section, entry *base.Entry) {
+	credits := entry.Credits
+	if len(credits.Groups) == 0 && len(credits.Persons) == 0 {
+		for _, name := range SplitNames(entry.Author) {
+			if author := index.get_author(name); author != nil {
+				author.add_entry(year, section, entry)
+			}
+		}
+		return
+	}
+	for _, group := range credits.Groups {
+		group_author := index.add_credit(year, section, entry, group, true)
+		for _, member := range group.Members {
+			member_author := index.add_credit(
+				year, section, entry, member, false)
+			if group_author == nil || member_author == nil {
+				continue
+			}
+			group_author.Members = add_related(
+				group_author.Members, member_author)
+			member_author.Groups = add_related(
+				member_author.Groups, group_author)
+		}
+	}
+	for _, person := range credits.Persons {
+		index.add_credit(year, section, entry, person, false)
+	}
+}
+
+// Creates an author index of all entries in the given years. Entries
+// with structured credits are indexed by their credited groups,
+// members, and persons, and plain author texts by the names in them.
+func New(years []*base.Year) *Index {
+	index := Index{
+		authors: make(map[string]*Author),
+		aliases: make(map[string]string),
+	}
+	// Aliases are collected first so that entries that use an alias
+	// end up on the same page regardless of the entry order.
+	for _, year := range years {
+		for _, section := range year.Sections {
+			for _, entry := range section.Entries {
+				for _, group := range entry.Credits.Groups {
+					index.add_aliases(group)
+				}
+				for _, person := range entry.Credits.Persons {
+					index.add_aliases(person)
+				}
+			}
+		}
+	}
+	for _, year := range years {
+		for _, section := range year.Sections {
+			for _, entry := range section.Entries {
+				index.add_entry(year, section, entry)
+			}
+		}
+	}
+	return &index
+}
+
+// Returns the author with the given key or alias key, or nil if no
+// such author exists.
+func (index *Index) Get(key string) *Author {
+	if canonical, ok := index.aliases[key]; ok {
+		key = canonical
+	}
+	return index.authors[key]
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"io"
 	"os"
+	"strings"
 )
 
 type SiteSettings struct {
@@ -12,11 +13,25 @@ type SiteSettings struct {
 	DataDir      string
 	StaticDir    string
 	TemplatesDir string
+	// Absolute URL of the public site root, like
+	// https://archive.assembly.org. Empty value means that the URL is
+	// derived from requests.
+	BaseUrl string
+	// Widths and types of images that are derived from original
+	// images of uploaded entries.
+	ImageWidths []int
+	ImageTypes  []string
+	// Uploaded originals with more pixels are rejected before they
+	// are decoded.
+	ImageMaxPixels int
+	// Optional file that indexes the whole data directory for faster
+	// startups.
+	StartupIndex string
 }
 
 type Resolution struct {
-	X int
-	Y int
+	X int `json:"x"`
+	Y int `json:"y"`
 }
 
 type ImageInfo struct {
@@ -43,20 +58,73 @@ type ExternalLinksSection struct {
 	Links []ExternalLink
 }
 
+// File that is offered as a download, like a production archive.
+type DownloadFile struct {
+	Filename string
+	Path     string
+	FsPath   string
+	Size     int64
+	Sha256   string
+	Platform string
+}
+
 type Asset struct {
-	Type string
-	Data interface{}
+	Type  string
+	Title string
+	Data  interface{}
+}
+
+// Group or person credited for an entry. Aliases are other handles
+// of the same group or person and members are only used for groups.
+type Credit struct {
+	Name    string
+	Aliases []string
+	// Roles like "code", "music", or "graphics".
+	Roles   []string
+	Members []Credit
+}
+
+type Credits struct {
+	Groups  []Credit
+	Persons []Credit
+}
+
+// Returns the names of credited groups and persons in a display
+// format, like "Future Crew & Skaven". Group members are left out.
+func CreditsTitle(credits Credits) string {
+	var names []string
+	for _, group := range credits.Groups {
+		names = append(names, group.Name)
+	}
+	for _, person := range credits.Persons {
+		names = append(names, person.Name)
+	}
+	return strings.Join(names, " & ")
+}
+
+// Competition result of an entry. Placement is 0 when it is not
+// known. Entries that share a place have the same placement.
+type Ranking struct {
+	Placement   int
+	SharedPlace bool
+	Points      float64
+	HasPoints   bool
 }
 
-// Structure that has all known data about an entry.
+// Structure that has all known data about an entry. Asset is the
+// primary asset of an entry and ExtraAssets are shown after it.
 type Entry struct {
 	Path          string
 	Key           string
 	Title         string
 	Author        string
+	Credits       Credits
+	Ranking       Ranking
 	Asset         Asset
+	ExtraAssets   []Asset
 	Description   string
 	ExternalLinks []ExternalLinksSection
+	Files         []DownloadFile
 	Thumbnails    Thumbnails
 }
 
@@ -67,7 +135,10 @@ type Section struct {
 	Description string
 	IsRanked    bool
 	IsOngoing   bool
-	Entries     []*Entry
+	// Competition family that groups sections of different years
+	// together. Empty for sections that are grouped by their key.
+	Family  string
+	Entries []*Entry
 }
 
 type Year struct {
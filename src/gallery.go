@@ -0,0 +1,405 @@
+package gallery
+
+import (
+	"assets"
+	"base"
+	"encoding/json"
+	"fmt"
+	"image"
+	"images"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"state"
+	"strings"
+	"sync"
+)
+
+// Image source widths that are generated for each photo. Photos that
+// are narrower than the widest one also get a source in their own
+// width.
+var DEFAULT_WIDTHS = []int{640, 1280, 1920}
+
+var THUMBNAIL_SIZE = base.Resolution{X: 160, Y: 90}
+
+// Thumbnails are also generated in multiples of their size for high
+// density displays.
+var THUMBNAIL_SCALES = []int{2}
+
+type Options struct {
+	// Section name. Directory name is used when the section does not
+	// have a name yet.
+	Name   string
+	Widths []int
+	// Types of the resized images, like "image/jpeg". Type of the
+	// original image is used when no types are given.
+	Types []string
+	// Number of images that are processed concurrently. Each job
+	// holds up to two decoded copies of an image in memory, 4 bytes
+	// per pixel, so 50 megapixel photos take about 400 MB per job.
+	Jobs int
+	// Larger images are not decoded. Zero means
+	// images.DEFAULT_MAX_PIXELS.
+	MaxPixels int
+}
+
+type photo struct {
+	Filename string
+	Title    string
+	Key      string
+}
+
+type image_asset_meta struct {
+	Type string           `json:"type"`
+	Data assets.ImageMeta `json:"data"`
+}
+
+// Returns image files of a directory in file name order.
+func find_photos(source_dir string) ([]photo, error) {
+	files, err_files := ioutil.ReadDir(source_dir)
+	if err_files != nil {
+		return nil, err_files
+	}
+	var result []photo
+	used := make(map[string]bool)
+	for _, file := range files {
+		if file.IsDir() || images.TypeOf(file.Name()) == "" {
+			continue
+		}
+		title := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		title = strings.Replace(title, "_", " ", -1)
+		key := state.EntryKey(title, used)
+		used[key] = true
+		result = append(result, photo{
+			Filename: filepath.Join(source_dir, file.Name()),
+			Title:    title,
+			Key:      key,
+		})
+	}
+	return result, nil
+}
+
+// Writes a resized image to the entry directory and returns its
+// metadata with the checksum of the written file.
+func write_image(
+	entry_dir string,
+	prefix string,
+	source *image.RGBA,
+	size base.Resolution,
+	image_type string) (assets.ImageInfoMeta, error) {
+	filename := fmt.Sprintf(
+		"%s-%dw%s", prefix, size.X, images.TYPE_EXTENSIONS[image_type])
+	target := filepath.Join(entry_dir, filename)
+	resized := source
+	if source.Bounds().Dx() != size.X || source.Bounds().Dy() != size.Y {
+		resized = images.Resize(source, size)
+	}
+	if err := images.Save(target, resized, image_type); err != nil {
+		return assets.ImageInfoMeta{}, err
+	}
+	checksum, err_checksum := base.CreateFileChecksum(target)
+	if err_checksum != nil {
+		return assets.ImageInfoMeta{}, err_checksum
+	}
+	return assets.ImageInfoMeta{
+		Filename: filename,
+		Type:     image_type,
+		Checksum: checksum,
+		Size:     size,
+	}, nil
+}
+
+func source_widths(original_width int, widths []int) []int {
+	var result []int
+	widest := 0
+	for _, width := range widths {
+		if width < original_width {
+			result = append(result, width)
+		}
+		if width > widest {
+			widest = width
+		}
+	}
+	if original_width <= widest {
+		result = append(result, original_width)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// Writes resized image sources and thumbnails of an original image
+// to an entry directory. Default image is the widest source of the
+// first image type and default thumbnail is the smallest thumbnail.
+func Derive(
+	original string,
+	entry_dir string,
+	options Options) (assets.ImageMeta, state.ThumbnailsMeta, error) {
+	var image_meta assets.ImageMeta
+	var thumbnails state.ThumbnailsMeta
+	max_pixels := options.MaxPixels
+	if max_pixels == 0 {
+		max_pixels = images.DEFAULT_MAX_PIXELS
+	}
+	source, original_type, err_load := images.Load(original, max_pixels)
+	if err_load != nil {
+		return image_meta, thumbnails, err_load
+	}
+	widths := options.Widths
+	if len(widths) == 0 {
+		widths = DEFAULT_WIDTHS
+	}
+	image_types := options.Types
+	if len(image_types) == 0 {
+		image_types = []string{original_type}
+	}
+	original_size := base.Resolution{
+		X: source.Bounds().Dx(),
+		Y: source.Bounds().Dy(),
+	}
+	cropped := images.CropCenter(source, THUMBNAIL_SIZE)
+	for type_index, image_type := range image_types {
+		for _, width := range source_widths(original_size.X, widths) {
+			source_meta, err := write_image(
+				entry_dir,
+				"image",
+				source,
+				images.ScaledSize(original_size, width),
+				image_type)
+			if err != nil {
+				return image_meta, thumbnails, err
+			}
+			image_meta.Sources = append(image_meta.Sources, source_meta)
+			if type_index == 0 {
+				image_meta.Default = source_meta
+			}
+		}
+
+		thumbnail_default, err_default := write_image(
+			entry_dir, "thumbnail", cropped, THUMBNAIL_SIZE, image_type)
+		if err_default != nil {
+			return image_meta, thumbnails, err_default
+		}
+		if type_index == 0 {
+			thumbnails.Default = thumbnail_default
+		} else {
+			thumbnails.Sources = append(thumbnails.Sources, thumbnail_default)
+		}
+		for _, scale := range THUMBNAIL_SCALES {
+			size := base.Resolution{
+				X: THUMBNAIL_SIZE.X * scale,
+				Y: THUMBNAIL_SIZE.Y * scale,
+			}
+			if size.X > cropped.Bounds().Dx() {
+				continue
+			}
+			thumbnail, err := write_image(
+				entry_dir, "thumbnail", cropped, size, image_type)
+			if err != nil {
+				return image_meta, thumbnails, err
+			}
+			thumbnails.Sources = append(thumbnails.Sources, thumbnail)
+		}
+	}
+	return image_meta, thumbnails, nil
+}
+
+// Resized images and thumbnails have these file names, so that files
+// of earlier widths and types can be removed.
+var DERIVED_IMAGE_FILENAME = regexp.MustCompile(
+	`^(image|thumbnail)-[0-9]+w\.(jpg|png)$`)
+
+// Removes derived images that the new image metadata does not refer
+// to, like images of widths that are no longer generated.
+func remove_stale_images(
+	entry_dir string,
+	image_meta assets.ImageMeta,
+	thumbnails state.ThumbnailsMeta) error {
+	used := map[string]bool{
+		image_meta.Default.Filename: true,
+		thumbnails.Default.Filename: true,
+	}
+	for _, source := range append(image_meta.Sources, thumbnails.Sources...) {
+		used[source.Filename] = true
+	}
+	files, err_files := ioutil.ReadDir(entry_dir)
+	if err_files != nil {
+		return err_files
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || used[name] || !DERIVED_IMAGE_FILENAME.MatchString(name) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(entry_dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func generate_entry(section_dir string, entry photo, options Options) error {
+	entry_dir := filepath.Join(section_dir, entry.Key)
+	if err := os.MkdirAll(entry_dir, 0755); err != nil {
+		return err
+	}
+	image_meta, thumbnails, err_derive := Derive(
+		entry.Filename, entry_dir, options)
+	if err_derive != nil {
+		return err_derive
+	}
+
+	// Titles and other manually written fields are kept when
+	// galleries are generated again.
+	meta, err_meta := state.ReadMetaFields(entry_dir)
+	if err_meta != nil {
+		return err_meta
+	}
+	if _, ok := meta["title"]; !ok {
+		meta.Set("title", entry.Title)
+	}
+	meta.Set("asset", image_asset_meta{Type: "image", Data: image_meta})
+	meta.Set("thumbnails", thumbnails)
+	if err := state.WriteMetaFields(entry_dir, meta); err != nil {
+		return err
+	}
+	return remove_stale_images(entry_dir, image_meta, thumbnails)
+}
+
+func derive_original(entry_dir string, options Options) (bool, error) {
+	meta, err_meta := state.ReadMetaFields(entry_dir)
+	if err_meta != nil {
+		return false, err_meta
+	}
+	data, ok := meta["original"]
+	if !ok {
+		return false, nil
+	}
+	var original string
+	if err := json.Unmarshal(data, &original); err != nil {
+		return false, fmt.Errorf("%s: Invalid original image: %v", entry_dir, err)
+	}
+	if original == "" ||
+		filepath.Base(original) != original ||
+		strings.HasPrefix(original, ".") {
+		return false, fmt.Errorf(
+			"%s: Original image '%s' is not a valid file name",
+			entry_dir,
+			original)
+	}
+	image_meta, thumbnails, err_derive := Derive(
+		filepath.Join(entry_dir, original), entry_dir, options)
+	if err_derive != nil {
+		return false, err_derive
+	}
+	_, has_asset := meta["asset"]
+	_, has_assets := meta["assets"]
+	if !has_asset && !has_assets {
+		meta.Set("asset", image_asset_meta{Type: "image", Data: image_meta})
+	}
+	if _, ok := meta["thumbnails"]; !ok {
+		meta.Set("thumbnails", thumbnails)
+	}
+	// Derived images are ready after this, so reloading the entry
+	// from the disk does not need to resize them again.
+	delete(meta, "original")
+	if err := state.WriteMetaFields(entry_dir, meta); err != nil {
+		return true, err
+	}
+	return true, remove_stale_images(entry_dir, image_meta, thumbnails)
+}
+
+// Derives images for entries that name a single original image with
+// "original" field of their meta.json file instead of listing resized
+// images. Entries without an asset get the original as an image asset
+// and entries without thumbnails get thumbnails cropped from it.
+// Returns the number of entries that had an original image.
+func DeriveOriginals(directory string, options Options) (int, error) {
+	count := 0
+	err_walk := filepath.Walk(
+		directory,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || info.Name() != "meta.json" {
+				return nil
+			}
+			derived, err_derive := derive_original(filepath.Dir(path), options)
+			if derived {
+				count++
+			}
+			return err_derive
+		})
+	return count, err_walk
+}
+
+// Generates a photo section from the JPEG and PNG images of a source
+// directory. Each image becomes an entry with resized sources and
+// thumbnails. Entries that the section already lists keep their
+// order and new ones are appended after them. Returns the keys of the
+// generated entries.
+func Generate(
+	source_dir string, section_dir string, options Options) ([]string, error) {
+	photos, err_photos := find_photos(source_dir)
+	if err_photos != nil {
+		return nil, err_photos
+	}
+	if len(photos) == 0 {
+		return nil, fmt.Errorf("No JPEG or PNG images found in %s", source_dir)
+	}
+	jobs := options.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	entry_errors := make([]error, len(photos))
+	var wait sync.WaitGroup
+	slots := make(chan bool, jobs)
+	for index := range photos {
+		wait.Add(1)
+		slots <- true
+		go func(index int) {
+			defer wait.Done()
+			defer func() { <-slots }()
+			entry_errors[index] = generate_entry(
+				section_dir, photos[index], options)
+		}(index)
+	}
+	wait.Wait()
+	var keys []string
+	for index, err := range entry_errors {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", photos[index].Filename, err)
+		}
+		keys = append(keys, photos[index].Key)
+	}
+
+	section_meta, err_meta := state.ReadMetaFields(section_dir)
+	if err_meta != nil {
+		return nil, err_meta
+	}
+	if options.Name != "" {
+		section_meta.Set("name", options.Name)
+	} else if _, ok := section_meta["name"]; !ok {
+		section_meta.Set("name", filepath.Base(section_dir))
+	}
+	var entries []string
+	if data, ok := section_meta["entries"]; ok {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("%s: %v", section_dir, err)
+		}
+	}
+	listed := make(map[string]bool)
+	for _, key := range entries {
+		listed[key] = true
+	}
+	for _, key := range keys {
+		if !listed[key] {
+			entries = append(entries, key)
+		}
+	}
+	section_meta.Set("entries", entries)
+	return keys, state.WriteMetaFields(section_dir, section_meta)
+}
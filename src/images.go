@@ -0,0 +1,325 @@
+package images
+
+import (
+	"base"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var TYPE_JPEG = "image/jpeg"
+var TYPE_PNG = "image/png"
+var TYPE_GIF = "image/gif"
+
+var JPEG_QUALITY = 85
+
+// Images are decoded into memory in full, so larger images than this
+// are rejected by default before decoding them.
+var DEFAULT_MAX_PIXELS = 50 * 1000 * 1000
+
+// File extensions of images that can be resized.
+var EXTENSION_TYPES = map[string]string{
+	".jpg":  TYPE_JPEG,
+	".jpeg": TYPE_JPEG,
+	".png":  TYPE_PNG,
+}
+
+var TYPE_EXTENSIONS = map[string]string{
+	TYPE_JPEG: ".jpg",
+	TYPE_PNG:  ".png",
+}
+
+// Image types whose headers ReadSize is able to read.
+var READABLE_TYPES = map[string]bool{
+	TYPE_GIF:  true,
+	TYPE_JPEG: true,
+	TYPE_PNG:  true,
+}
+
+// Returns the image type of a file name or an empty string for files
+// that are not resizable images.
+func TypeOf(filename string) string {
+	return EXTENSION_TYPES[strings.ToLower(filepath.Ext(filename))]
+}
+
+// EXIF tag that tells how the camera was held when a JPEG image was
+// taken.
+var EXIF_ORIENTATION_TAG = uint16(0x0112)
+
+// Reads the EXIF orientation of a JPEG image. Orientation 1 means that
+// the image is stored upright, and it is also returned when the image
+// does not have EXIF data.
+func read_orientation(input io.Reader) int {
+	reader := bufio.NewReader(input)
+	var marker [2]byte
+	if _, err := io.ReadFull(reader, marker[:]); err != nil ||
+		marker != [2]byte{0xff, 0xd8} {
+		return 1
+	}
+	for {
+		if _, err := io.ReadFull(reader, marker[:]); err != nil ||
+			marker[0] != 0xff {
+			return 1
+		}
+		// EXIF data comes before the image data.
+		if marker[1] == 0xda || marker[1] == 0xd9 {
+			return 1
+		}
+		var length uint16
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil ||
+			length < 2 {
+			return 1
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return 1
+		}
+		is_exif := bytes.HasPrefix(segment, []byte("Exif\x00\x00"))
+		if marker[1] == 0xe1 && is_exif {
+			return read_exif_orientation(segment[6:])
+		}
+	}
+}
+
+// Reads the orientation from the first image directory of EXIF data
+// in TIFF format.
+func read_exif_orientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for index := 0; index < count; index++ {
+		field := offset + 2 + index*12
+		if field+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[field:]) == EXIF_ORIENTATION_TAG {
+			orientation := int(order.Uint16(tiff[field+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// Turns an image with the given EXIF orientation upright.
+// Orientations 5-8 swap the width and the height.
+func orient(source *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 {
+		return source
+	}
+	width := source.Bounds().Dx()
+	height := source.Bounds().Dy()
+	size := image.Rect(0, 0, width, height)
+	if orientation >= 5 {
+		size = image.Rect(0, 0, height, width)
+	}
+	result := image.NewRGBA(size)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var target_x, target_y int
+			switch orientation {
+			case 2:
+				target_x, target_y = width-1-x, y
+			case 3:
+				target_x, target_y = width-1-x, height-1-y
+			case 4:
+				target_x, target_y = x, height-1-y
+			case 5:
+				target_x, target_y = y, x
+			case 6:
+				target_x, target_y = height-1-y, x
+			case 7:
+				target_x, target_y = height-1-y, width-1-x
+			case 8:
+				target_x, target_y = y, width-1-x
+			}
+			source_offset := source.PixOffset(
+				source.Bounds().Min.X+x, source.Bounds().Min.Y+y)
+			target_offset := result.PixOffset(target_x, target_y)
+			copy(
+				result.Pix[target_offset:target_offset+4],
+				source.Pix[source_offset:source_offset+4])
+		}
+	}
+	return result
+}
+
+// Decodes an image file into RGBA format that can be resized. Image
+// type is detected from the file contents and JPEG images are turned
+// upright by their EXIF orientation. Images with more than the given
+// number of pixels are rejected before they are decoded.
+func Load(filename string, max_pixels int) (*image.RGBA, string, error) {
+	input, err_open := os.Open(filename)
+	if err_open != nil {
+		return nil, "", err_open
+	}
+	defer input.Close()
+	config, _, err_config := image.DecodeConfig(input)
+	if err_config != nil {
+		return nil, "", fmt.Errorf("%s: %v", filename, err_config)
+	}
+	pixels := int64(config.Width) * int64(config.Height)
+	if pixels > int64(max_pixels) {
+		return nil, "", fmt.Errorf(
+			"%s: Image size %dx%d exceeds the maximum of %d pixels",
+			filename,
+			config.Width,
+			config.Height,
+			max_pixels)
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	decoded, format, err_decode := image.Decode(input)
+	if err_decode != nil {
+		return nil, "", fmt.Errorf("%s: %v", filename, err_decode)
+	}
+	image_type := "image/" + format
+	if _, ok := TYPE_EXTENSIONS[image_type]; !ok {
+		return nil, "", fmt.Errorf(
+			"%s: Unsupported image type %s", filename, image_type)
+	}
+	bounds := decoded.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(result, result.Bounds(), decoded, bounds.Min, draw.Src)
+	if image_type == TYPE_JPEG {
+		if _, err := input.Seek(0, io.SeekStart); err != nil {
+			return nil, "", err
+		}
+		result = orient(result, read_orientation(input))
+	}
+	return result, image_type, nil
+}
+
+// Returns the size of an image with the given width that keeps the
+// aspect ratio of the original size.
+func ScaledSize(size base.Resolution, width int) base.Resolution {
+	if size.X == 0 {
+		return base.Resolution{}
+	}
+	height := (size.Y*width + size.X/2) / size.X
+	if height < 1 {
+		height = 1
+	}
+	return base.Resolution{X: width, Y: height}
+}
+
+// Crops the largest possible area from the center of an image that
+// has the aspect ratio of the given size.
+func CropCenter(source *image.RGBA, aspect base.Resolution) *image.RGBA {
+	bounds := source.Bounds()
+	width := bounds.Dx()
+	height := width * aspect.Y / aspect.X
+	if height > bounds.Dy() {
+		height = bounds.Dy()
+		width = height * aspect.X / aspect.Y
+	}
+	left := bounds.Min.X + (bounds.Dx()-width)/2
+	top := bounds.Min.Y + (bounds.Dy()-height)/2
+	return source.SubImage(
+		image.Rect(left, top, left+width, top+height)).(*image.RGBA)
+}
+
+// Resizes an image by averaging the source pixels that each result
+// pixel covers. This is meant for making images smaller, as there is
+// no interpolation when the size grows.
+func Resize(source *image.RGBA, size base.Resolution) *image.RGBA {
+	bounds := source.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	for y := 0; y < size.Y; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/size.Y
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/size.Y
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size.X; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/size.X
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/size.X
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sums [4]uint64
+			for source_y := y0; source_y < y1; source_y++ {
+				offset := source.PixOffset(x0, source_y)
+				for source_x := x0; source_x < x1; source_x++ {
+					for channel := 0; channel < 4; channel++ {
+						sums[channel] += uint64(source.Pix[offset+channel])
+					}
+					offset += 4
+				}
+			}
+			count := uint64((x1 - x0) * (y1 - y0))
+			offset := result.PixOffset(x, y)
+			for channel := 0; channel < 4; channel++ {
+				result.Pix[offset+channel] = uint8(
+					(sums[channel] + count/2) / count)
+			}
+		}
+	}
+	return result
+}
+
+// Encodes an image into a file of the given type.
+func Save(filename string, source image.Image, image_type string) error {
+	output, err_create := os.Create(filename)
+	if err_create != nil {
+		return err_create
+	}
+	var err_encode error
+	switch image_type {
+	case TYPE_JPEG:
+		err_encode = jpeg.Encode(
+			output, source, &jpeg.Options{Quality: JPEG_QUALITY})
+	case TYPE_PNG:
+		err_encode = png.Encode(output, source)
+	default:
+		err_encode = fmt.Errorf("Unsupported image type %s", image_type)
+	}
+	err_close := output.Close()
+	if err_encode != nil {
+		os.Remove(filename)
+		return err_encode
+	}
+	return err_close
+}
+
+// Returns the size of an image file without decoding all of it. Decoding
+// errors do not include the file name.
+func ReadSize(filename string) (base.Resolution, string, error) {
+	input, err_open := os.Open(filename)
+	if err_open != nil {
+		return base.Resolution{}, "", err_open
+	}
+	defer input.Close()
+	config, format, err_config := image.DecodeConfig(input)
+	if err_config != nil {
+		return base.Resolution{}, "", err_config
+	}
+	return base.Resolution{X: config.Width, Y: config.Height}, "image/" + format, nil
+}
@@ -4,20 +4,31 @@ import (
 	"api"
 	"base"
 	"compress/gzip"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"gallery"
+	"images"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"results"
+	"runtime"
 	"server"
 	"site"
+	"siteapi"
 	"state"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+	"watcher"
 )
 
 func RenderTeapot(w http.ResponseWriter, r *http.Request) {
@@ -98,6 +109,7 @@ func RenderLinks(w http.ResponseWriter, r *http.Request) {
 <p>This offers following namespaces:</p>
 <ul>
 <li><a href="/api/">/api/</a> for database manipulation. Requires authentication.</li>
+<li><a href="/api/status">/api/status</a> lists years and sections that failed to load. Requires authentication.</li>
 <li><a href="/site/">/site/</a> should be exposed through a reverse proxy as the site root</li>
 <li><a href="/teapot/">/teapot/</a> I'm a teapot!</li>
 <li><a href="/exit/">/exit/</a> make me quit, only in <code>-dev</code> mode</li>
@@ -119,7 +131,212 @@ func exit(w http.ResponseWriter, r *http.Request) {
 	os.Exit(0)
 }
 
+// Reloads the site state, templates, and static files when SIGHUP
+// signal is received. New versions are taken into use only if all of
+// them load successfully, but years and sections that fail to load
+// are quarantined like at startup instead of failing the reload.
+func reload_on_sighup(
+	settings base.SiteSettings,
+	site_state *state.SiteState,
+	renderer *site.Renderer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		log.Printf("Received SIGHUP, reloading state from %s", settings.DataDir)
+		new_state, err_state := state.NewIndexed(
+			settings.DataDir, settings.SiteRoot, settings.StartupIndex)
+		if err_state != nil {
+			log.Printf("Reload failed, keeping the old version: %s", err_state)
+			continue
+		}
+		resources, err_resources := site.LoadResources(settings)
+		if err_resources != nil {
+			log.Printf("Reload failed, keeping the old version: %s", err_resources)
+			continue
+		}
+		site_state.ReplaceYears(new_state.Years())
+		site_state.ReplaceLoadErrors(new_state.LoadErrors())
+		renderer.ReplaceResources(resources)
+		log.Println("Reload finished")
+	}
+}
+
+// Writes placements and points from a results file to the entries
+// of a section directory.
+func import_results(args []string) error {
+	flags := flag.NewFlagSet("import-results", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(
+			flags.Output(),
+			"Usage: %s import-results SECTION-DIR RESULTS-FILE\n",
+			os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	section_dir := flags.Arg(0)
+	results_file := flags.Arg(1)
+	input, err_open := os.Open(results_file)
+	if err_open != nil {
+		return err_open
+	}
+	defer input.Close()
+	entry_results, err_parse := results.Parse(results_file, input)
+	if err_parse != nil {
+		return err_parse
+	}
+	report, err_import := results.Import(section_dir, entry_results)
+	if err_import != nil {
+		return err_import
+	}
+	fmt.Printf("Updated %d entries in %s\n", len(report.Updated), section_dir)
+	for _, result := range report.OnlyInResults {
+		fmt.Printf(
+			"Only in results, not imported: %d. %s by %s (no directory %s)\n",
+			result.Placement,
+			result.Title,
+			result.Author,
+			result.Key)
+	}
+	for _, key := range report.OnlyInSection {
+		fmt.Printf("Only in section: %s\n", key)
+	}
+	for _, key := range report.Unlisted {
+		fmt.Printf("Not listed in section: %s\n", key)
+	}
+	return nil
+}
+
+// Parses comma separated image widths, like "640,1280,1920".
+func parse_image_widths(text string) ([]int, error) {
+	var result []int
+	for _, width_text := range strings.Split(text, ",") {
+		width, err_width := strconv.Atoi(strings.TrimSpace(width_text))
+		if err_width != nil || width <= 0 {
+			return nil, fmt.Errorf("Invalid image width %s", width_text)
+		}
+		result = append(result, width)
+	}
+	return result, nil
+}
+
+// Parses comma separated image types, like "image/jpeg,image/png".
+func parse_image_types(text string) ([]string, error) {
+	var result []string
+	for _, image_type := range strings.Split(text, ",") {
+		image_type = strings.TrimSpace(image_type)
+		if image_type == "" {
+			continue
+		}
+		if _, ok := images.TYPE_EXTENSIONS[image_type]; !ok {
+			return nil, fmt.Errorf("Unsupported image type %s", image_type)
+		}
+		result = append(result, image_type)
+	}
+	return result, nil
+}
+
+// Generates a photo section with resized images from a directory of
+// JPEG and PNG images.
+func generate_gallery(args []string) error {
+	flags := flag.NewFlagSet("gallery", flag.ExitOnError)
+	name := flags.String("name", "", "Section name")
+	widths := flags.String(
+		"widths", "640,1280,1920", "Comma separated image source widths")
+	image_types := flags.String(
+		"types",
+		"",
+		"Comma separated types of resized images, like image/jpeg. "+
+			"Defaults to the type of each original image")
+	jobs := flags.Int(
+		"jobs",
+		runtime.NumCPU(),
+		"Number of images to process concurrently, each needing up to "+
+			"8 bytes of memory per image pixel")
+	max_pixels := flags.Int(
+		"max-pixels",
+		images.DEFAULT_MAX_PIXELS,
+		"Maximum number of pixels in an image")
+	flags.Usage = func() {
+		fmt.Fprintf(
+			flags.Output(),
+			"Usage: %s gallery [options] IMAGE-DIR SECTION-DIR\n",
+			os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	options := gallery.Options{Name: *name, Jobs: *jobs, MaxPixels: *max_pixels}
+	var err_widths, err_types error
+	options.Widths, err_widths = parse_image_widths(*widths)
+	if err_widths != nil {
+		return err_widths
+	}
+	options.Types, err_types = parse_image_types(*image_types)
+	if err_types != nil {
+		return err_types
+	}
+	section_dir := flags.Arg(1)
+	keys, err_generate := gallery.Generate(flags.Arg(0), section_dir, options)
+	if err_generate != nil {
+		return err_generate
+	}
+	fmt.Printf("Generated %d entries in %s\n", len(keys), section_dir)
+	return nil
+}
+
+// Validates a data directory without starting the server and prints a
+// JSON report of all problems that were found.
+func validate_archive(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(
+			flags.Output(), "Usage: %s validate DATA-DIR\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	report := state.Validate(flags.Arg(0))
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if len(report.Problems) > 0 {
+		return fmt.Errorf(
+			"Found %d problems in %s", len(report.Problems), report.Directory)
+	}
+	return nil
+}
+
+// Subcommands are given as the first argument, like
+// "assembly-archive import-results ...". Without a subcommand the
+// server is started.
+var SUBCOMMANDS = map[string]func(args []string) error{
+	"gallery":        generate_gallery,
+	"import-results": import_results,
+	"validate":       validate_archive,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if subcommand, ok := SUBCOMMANDS[os.Args[1]]; ok {
+			if err := subcommand(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	host := flag.String("host", "localhost", "Host interface to listen to")
 	port := flag.Int("port", 8080, "Port to listen to")
 	data_dir := flag.String("dir-data", "_data", "Data directory")
@@ -128,14 +345,52 @@ func main() {
 		"dir-templates", "templates", "Site templates directory")
 	authfile := flag.String("authfile", "auth.txt", "File with username:password lines")
 	devmode := flag.Bool("dev", false, "Enable development mode")
+	base_url := flag.String(
+		"base-url",
+		"",
+		"Absolute public URL of the site, like https://archive.assembly.org")
+	watch := flag.Bool(
+		"watch", false, "Reload changed meta.json files from the data directory")
+	watch_interval := flag.Duration(
+		"watch-interval", 5*time.Second, "Data directory polling interval")
+	image_widths := flag.String(
+		"image-widths",
+		"640,1280,1920",
+		"Comma separated widths of images derived from uploaded originals")
+	image_types := flag.String(
+		"image-types",
+		"image/jpeg",
+		"Comma separated types of images derived from uploaded originals")
+	image_max_pixels := flag.Int(
+		"image-max-pixels",
+		images.DEFAULT_MAX_PIXELS,
+		"Maximum number of pixels in uploaded original images")
+	startup_index := flag.String(
+		"startup-index",
+		"",
+		"Optional file that indexes the whole data directory for faster startups")
 
 	flag.Parse()
 
+	parsed_widths, err_widths := parse_image_widths(*image_widths)
+	if err_widths != nil {
+		log.Fatal(err_widths)
+	}
+	parsed_types, err_types := parse_image_types(*image_types)
+	if err_types != nil {
+		log.Fatal(err_types)
+	}
+
 	settings := base.SiteSettings{
-		SiteRoot:     "",
-		DataDir:      *data_dir,
-		StaticDir:    *static_dir,
-		TemplatesDir: *templates_dir,
+		SiteRoot:       "",
+		DataDir:        *data_dir,
+		StaticDir:      *static_dir,
+		TemplatesDir:   *templates_dir,
+		BaseUrl:        strings.TrimSuffix(*base_url, "/"),
+		ImageWidths:    parsed_widths,
+		ImageTypes:     parsed_types,
+		ImageMaxPixels: *image_max_pixels,
+		StartupIndex:   *startup_index,
 	}
 
 	if *devmode {
@@ -147,10 +402,22 @@ func main() {
 	}
 
 	log.Printf("Recreating state from %s", settings.DataDir)
-	state, err_state := state.New(settings.DataDir, settings.SiteRoot)
+	state, err_state := state.NewIndexed(
+		settings.DataDir, settings.SiteRoot, settings.StartupIndex)
 	if err_state != nil {
 		log.Fatal(err_state)
 	}
+	if *watch {
+		log.Printf(
+			"Watching %s for changes every %s", settings.DataDir, *watch_interval)
+		go watcher.New(state, *watch_interval).Run()
+	}
+
+	site_renderer, err_site := site.NewRenderer(settings, state)
+	if err_site != nil {
+		log.Fatal(err_site)
+	}
+	go reload_on_sighup(settings, state, site_renderer)
 
 	http.HandleFunc("/api/", server.StripPrefix("/api/",
 		server.BasicAuth(*authfile, api.Renderer(settings, state))))
@@ -158,8 +425,12 @@ func main() {
 	http.Handle("/site/",
 		CompressGzipHandler(
 			regexp.MustCompile(""),
-			server.StripPrefix("/site/",
-				http.HandlerFunc(site.SiteRenderer(settings, state)))))
+			server.StripPrefix("/site/", site_renderer.ServeHTTP)))
+	http.Handle("/site/_api/",
+		CompressGzipHandler(
+			regexp.MustCompile(""),
+			server.StripPrefix(
+				"/site/_api/", siteapi.Renderer(settings, state))))
 	http.HandleFunc("/teapot/", RenderTeapot)
 	http.Handle(
 		"/site/_data/",
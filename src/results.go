@@ -0,0 +1,360 @@
+package results
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"state"
+	"strconv"
+	"strings"
+)
+
+// Plain text result lines look like "1. 1234 pts Title by Author",
+// where points and the author are optional. Numbers are only points
+// when they have a unit, so titles can start with numbers. Lines that
+// do not start with a rank followed by ".", ")", or "=", like
+// headers, are ignored.
+var TEXT_RESULT_LINE = regexp.MustCompile(
+	`^\s*(\d{1,3})[.)=]\s+(?:(\d+(?:\.\d+)?)\s*(?:pts|points|p)\s+)?(.+?)\s*$`)
+
+// Titles can contain "by", so the author follows the last one.
+var AUTHOR_SEPARATOR = regexp.MustCompile(`\s+by\s+`)
+
+// Single entry in a results file. Placement is 0 for entries that
+// did not get a placement, like disqualified ones.
+type Result struct {
+	Placement   int
+	SharedPlace bool
+	Points      float64
+	HasPoints   bool
+	Title       string
+	Author      string
+	// Key of the entry directory that the result was written to. For
+	// results without a matching entry, this is a free key that a new
+	// entry directory can use.
+	Key string
+}
+
+// Outcome of an import. Results without a matching entry directory
+// are only reported and not written anywhere, as new entries without
+// assets would not be valid. Their entries can be added under the
+// reported keys and the results imported again.
+type Report struct {
+	Updated       []string
+	OnlyInResults []Result
+	OnlyInSection []string
+	// Entry directories that the section metadata does not list. They
+	// are neither matched with results nor added to the section.
+	Unlisted []string
+}
+
+func parse_rank(value string) (int, error) {
+	value = strings.Trim(strings.TrimSpace(value), ".)=")
+	if value == "" || value == "-" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func parse_points(value string) (float64, bool, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false, nil
+	}
+	points, err := strconv.ParseFloat(value, 64)
+	return points, err == nil, err
+}
+
+// Reads comma or tab separated rows of rank, points, title, and
+// author. First row is skipped when it is a header.
+func parse_csv(reader io.Reader, separator rune) ([]Result, error) {
+	csv_reader := csv.NewReader(reader)
+	csv_reader.Comma = separator
+	csv_reader.FieldsPerRecord = -1
+	csv_reader.TrimLeadingSpace = true
+	rows, err_read := csv_reader.ReadAll()
+	if err_read != nil {
+		return nil, err_read
+	}
+	var result []Result
+	for index, row := range rows {
+		if len(row) < 3 {
+			return nil, fmt.Errorf(
+				"Row %d has %d columns, expected rank, points, title, and author",
+				index+1,
+				len(row))
+		}
+		placement, err_rank := parse_rank(row[0])
+		if err_rank != nil {
+			if index == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("Row %d has invalid rank %s", index+1, row[0])
+		}
+		points, has_points, err_points := parse_points(row[1])
+		if err_points != nil {
+			return nil, fmt.Errorf(
+				"Row %d has invalid points %s", index+1, row[1])
+		}
+		entry := Result{
+			Placement: placement,
+			Points:    points,
+			HasPoints: has_points,
+			Title:     strings.TrimSpace(row[2]),
+		}
+		if len(row) > 3 {
+			entry.Author = strings.TrimSpace(row[3])
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+func parse_text(reader io.Reader) ([]Result, error) {
+	data, err_read := ioutil.ReadAll(reader)
+	if err_read != nil {
+		return nil, err_read
+	}
+	var result []Result
+	for _, line := range strings.Split(string(data), "\n") {
+		match := TEXT_RESULT_LINE.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		placement, _ := strconv.Atoi(match[1])
+		points, has_points, _ := parse_points(match[2])
+		title := match[3]
+		var author string
+		separators := AUTHOR_SEPARATOR.FindAllStringIndex(title, -1)
+		if len(separators) > 0 {
+			last := separators[len(separators)-1]
+			author = title[last[1]:]
+			title = title[:last[0]]
+		}
+		result = append(result, Result{
+			Placement: placement,
+			Points:    points,
+			HasPoints: has_points,
+			Title:     title,
+			Author:    author,
+		})
+	}
+	return result, nil
+}
+
+// Entries that have the same placement share their place.
+func mark_shared_places(results []Result) {
+	counts := make(map[int]int)
+	for _, result := range results {
+		counts[result.Placement]++
+	}
+	for index := range results {
+		placement := results[index].Placement
+		results[index].SharedPlace = placement > 0 && counts[placement] > 1
+	}
+}
+
+// Parses a results file. Files ending with .csv or .tsv are read as
+// comma or tab separated values and other files as plain text.
+func Parse(filename string, reader io.Reader) ([]Result, error) {
+	var results []Result
+	var err error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		results, err = parse_csv(reader, ',')
+	case ".tsv":
+		results, err = parse_csv(reader, '\t')
+	default:
+		results, err = parse_text(reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%s: No results found", filename)
+	}
+	mark_shared_places(results)
+	return results, nil
+}
+
+// Returns entry keys of a section in their current order and entry
+// directories that are not listed in the section metadata.
+func read_section_entries(
+	section_dir string,
+	section_meta state.MetaFields) ([]string, []string, error) {
+	var keys []string
+	if entries, ok := section_meta["entries"]; ok {
+		if err := json.Unmarshal(entries, &keys); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", section_dir, err)
+		}
+	}
+	listed := make(map[string]bool)
+	for _, key := range keys {
+		listed[key] = true
+	}
+	files, err_files := ioutil.ReadDir(section_dir)
+	if err_files != nil {
+		return nil, nil, err_files
+	}
+	var unlisted []string
+	for _, file := range files {
+		if !file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		if !listed[file.Name()] {
+			unlisted = append(unlisted, file.Name())
+		}
+	}
+	return keys, unlisted, nil
+}
+
+// Matches entries by their directory key or by their title.
+func match_entries(
+	section_dir string, keys []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, key := range keys {
+		result[key] = key
+		meta, err_meta := state.ReadMetaFields(filepath.Join(section_dir, key))
+		if err_meta != nil {
+			return nil, err_meta
+		}
+		var title string
+		if data, ok := meta["title"]; ok {
+			json.Unmarshal(data, &title)
+		}
+		if title != "" {
+			title_key := state.EntryKey(title, nil)
+			if _, ok := result[title_key]; !ok {
+				result[title_key] = key
+			}
+		}
+	}
+	return result, nil
+}
+
+var RANKING_FIELDS = []string{"placement", "points", "shared-place"}
+
+// Removes stale placements from entries that are not in the results.
+func clear_entry_ranking(entry_dir string) error {
+	meta, err_meta := state.ReadMetaFields(entry_dir)
+	if err_meta != nil {
+		return err_meta
+	}
+	changed := false
+	for _, field := range RANKING_FIELDS {
+		if _, ok := meta[field]; ok {
+			delete(meta, field)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return state.WriteMetaFields(entry_dir, meta)
+}
+
+func update_entry(entry_dir string, result Result) error {
+	meta, err_meta := state.ReadMetaFields(entry_dir)
+	if err_meta != nil {
+		return err_meta
+	}
+	if _, ok := meta["title"]; !ok {
+		meta.Set("title", result.Title)
+	}
+	if _, ok := meta["author"]; !ok && result.Author != "" {
+		meta.Set("author", result.Author)
+	}
+	for _, field := range RANKING_FIELDS {
+		delete(meta, field)
+	}
+	if result.Placement > 0 {
+		meta.Set("placement", result.Placement)
+	}
+	if result.HasPoints {
+		meta.Set("points", result.Points)
+	}
+	if result.SharedPlace {
+		meta.Set("shared-place", true)
+	}
+	return state.WriteMetaFields(entry_dir, meta)
+}
+
+// Writes results to the entry metadata files of a section and orders
+// the section entries by their placements. Section becomes a ranked
+// one and entries that are not in the results are placed last. Only
+// entries that the section lists are updated.
+func Import(section_dir string, results []Result) (Report, error) {
+	var report Report
+	section_meta, err_meta := state.ReadMetaFields(section_dir)
+	if err_meta != nil {
+		return report, err_meta
+	}
+	keys, unlisted, err_keys := read_section_entries(section_dir, section_meta)
+	if err_keys != nil {
+		return report, err_keys
+	}
+	report.Unlisted = unlisted
+	matches, err_matches := match_entries(section_dir, keys)
+	if err_matches != nil {
+		return report, err_matches
+	}
+
+	sorted := make([]Result, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Placement == 0 {
+			return false
+		}
+		return sorted[j].Placement == 0 ||
+			sorted[i].Placement < sorted[j].Placement
+	})
+
+	used := make(map[string]bool)
+	for _, key := range append(keys, unlisted...) {
+		used[key] = true
+	}
+	imported := make(map[string]bool)
+	var entries []string
+	for _, result := range sorted {
+		key, ok := matches[state.EntryKey(result.Title, nil)]
+		if !ok || imported[key] {
+			result.Key = state.EntryKey(result.Title, used)
+			used[result.Key] = true
+			report.OnlyInResults = append(report.OnlyInResults, result)
+			continue
+		}
+		result.Key = key
+		imported[key] = true
+		if err := update_entry(filepath.Join(section_dir, key), result); err != nil {
+			return report, err
+		}
+		entries = append(entries, key)
+		report.Updated = append(report.Updated, key)
+	}
+	for _, key := range keys {
+		if imported[key] {
+			continue
+		}
+		entry_dir := filepath.Join(section_dir, key)
+		if err := clear_entry_ranking(entry_dir); err != nil {
+			return report, err
+		}
+		entries = append(entries, key)
+		report.OnlyInSection = append(report.OnlyInSection, key)
+	}
+
+	if _, ok := section_meta["name"]; !ok {
+		section_meta.Set("name", filepath.Base(section_dir))
+	}
+	section_meta.Set("is-ranked", true)
+	if entries == nil {
+		entries = []string{}
+	}
+	section_meta.Set("entries", entries)
+	return report, state.WriteMetaFields(section_dir, section_meta)
+}
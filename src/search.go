@@ -0,0 +1,274 @@
+package search
+
+import (
+	"base"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Field weights for scoring. Title matches are more relevant than
+// matches in long descriptions.
+var WEIGHT_TITLE = 4
+var WEIGHT_AUTHOR = 3
+var WEIGHT_DESCRIPTION = 1
+
+// Letters with diacritics are folded to their base letters so that
+// "aanet" finds "Äänet" and "motorhead" finds "Motörhead". Finnish
+// keyboards have ä and ö, but people often search without them.
+var FOLDED_LETTERS = map[rune]rune{
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a',
+	'ç': 'c',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i',
+	'ñ': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o',
+	'š': 's',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u',
+	'ý': 'y', 'ÿ': 'y',
+	'ž': 'z',
+}
+
+// Entry that can be found with a search.
+type Document struct {
+	Year    *base.Year
+	Section *base.Section
+	Entry   *base.Entry
+}
+
+type Result struct {
+	Document
+	Score    int
+	document int
+}
+
+type Query struct {
+	Text string
+	// Optional year and section keys that limit the results.
+	Year    string
+	Section string
+}
+
+type posting struct {
+	document int
+	weight   int
+}
+
+// Inverted index of entry titles, authors, and descriptions. Index is
+// not modified after it has been created, so it can be shared between
+// concurrent searches.
+type Index struct {
+	documents []Document
+	postings  map[string][]posting
+	// All indexed tokens in sorted order for prefix searches.
+	tokens []string
+}
+
+func fold_rune(r rune) rune {
+	r = unicode.ToLower(r)
+	if folded, ok := FOLDED_LETTERS[r]; ok {
+		return folded
+	}
+	return r
+}
+
+// Converts text to lower case and folds letters with diacritics.
+func Fold(text string) string {
+	return strings.Map(fold_rune, text)
+}
+
+var NON_KEY_CHARACTERS = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Returns folded text where runs of other characters than latin
+// letters and digits are replaced with hyphens. Author and entry keys
+// are made with this so that they follow the same rules.
+func Slug(text string) string {
+	return strings.Trim(
+		NON_KEY_CHARACTERS.ReplaceAllString(Fold(text), "-"), "-")
+}
+
+// Splits text into lower case search tokens with folded diacritics.
+//
+// Scene handles often have punctuation inside them, like "Jml^vs" or
+// "Mr.Doc". Such words produce tokens of their parts and also a token
+// where the parts are joined together, so both "jml" and "jmlvs" find
+// the handle.
+func Tokenize(text string) []string {
+	var result []string
+	for _, word := range strings.Fields(text) {
+		parts := strings.FieldsFunc(
+			Fold(word),
+			func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})
+		result = append(result, parts...)
+		if len(parts) > 1 {
+			result = append(result, strings.Join(parts, ""))
+		}
+	}
+	return result
+}
+
+func (index *Index) add_field(document int, text string, weight int) {
+	for _, token := range Tokenize(text) {
+		postings := index.postings[token]
+		last := len(postings) - 1
+		if last >= 0 && postings[last].document == document {
+			postings[last].weight += weight
+			continue
+		}
+		index.postings[token] = append(
+			postings, posting{document: document, weight: weight})
+	}
+}
+
+// Returns names and aliases of all credited groups, members, and
+// persons that are not already part of the author text.
+func credit_names(author string, credits base.Credits) []string {
+	var result []string
+	var add func(credit base.Credit)
+	add = func(credit base.Credit) {
+		for _, name := range append([]string{credit.Name}, credit.Aliases...) {
+			if !strings.Contains(author, name) {
+				result = append(result, name)
+			}
+		}
+		for _, member := range credit.Members {
+			add(member)
+		}
+	}
+	for _, group := range credits.Groups {
+		add(group)
+	}
+	for _, person := range credits.Persons {
+		add(person)
+	}
+	return result
+}
+
+// Creates an index of all entries in the given years.
+func New(years []*base.Year) *Index {
+	index := Index{
+		postings: make(map[string][]posting),
+	}
+	for _, year := range years {
+		for _, section := range year.Sections {
+			for _, entry := range section.Entries {
+				document := len(index.documents)
+				index.documents = append(index.documents, Document{
+					Year:    year,
+					Section: section,
+					Entry:   entry,
+				})
+				index.add_field(document, entry.Title, WEIGHT_TITLE)
+				index.add_field(document, entry.Author, WEIGHT_AUTHOR)
+				for _, name := range credit_names(entry.Author, entry.Credits) {
+					index.add_field(document, name, WEIGHT_AUTHOR)
+				}
+				index.add_field(
+					document, entry.Description, WEIGHT_DESCRIPTION)
+			}
+		}
+	}
+	for token := range index.postings {
+		index.tokens = append(index.tokens, token)
+	}
+	sort.Strings(index.tokens)
+	return &index
+}
+
+// Returns document scores for documents that have a token starting
+// with the given query token. Exact matches score higher than prefix
+// matches, as "demo" should rank "Demo" before "Demoscene".
+func (index *Index) match_token(query_token string) map[int]int {
+	scores := make(map[int]int)
+	start := sort.SearchStrings(index.tokens, query_token)
+	for _, token := range index.tokens[start:] {
+		if !strings.HasPrefix(token, query_token) {
+			break
+		}
+		multiplier := 1
+		if token == query_token {
+			multiplier = 2
+		}
+		for _, match := range index.postings[token] {
+			scores[match.document] += match.weight * multiplier
+		}
+	}
+	return scores
+}
+
+func (index *Index) matches_filters(document int, query Query) bool {
+	if query.Year != "" && index.documents[document].Year.Key != query.Year {
+		return false
+	}
+	if query.Section != "" &&
+		index.documents[document].Section.Key != query.Section {
+		return false
+	}
+	return true
+}
+
+// Finds entries that match all tokens of the query. Results are in
+// the order of relevance and entries with equal scores are in the
+// archive order, newest years first.
+func (index *Index) Search(query Query) []Result {
+	var scores map[int]int
+	seen_tokens := make(map[string]bool)
+	for _, token := range Tokenize(query.Text) {
+		if seen_tokens[token] {
+			continue
+		}
+		seen_tokens[token] = true
+		token_scores := index.match_token(token)
+		if scores == nil {
+			scores = token_scores
+			continue
+		}
+		for document, score := range scores {
+			token_score, ok := token_scores[document]
+			if !ok {
+				delete(scores, document)
+				continue
+			}
+			scores[document] = score + token_score
+		}
+	}
+	var results []Result
+	for document, score := range scores {
+		if !index.matches_filters(document, query) {
+			continue
+		}
+		results = append(results, Result{
+			Document: index.documents[document],
+			Score:    score,
+			document: document,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].document < results[j].document
+	})
+	return results
+}
+
+// Returns distinct titles of the best matching entries for search
+// suggestions.
+func (index *Index) Suggest(text string, limit int) []string {
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, match := range index.Search(Query{Text: text}) {
+		if len(result) >= limit {
+			break
+		}
+		if seen[match.Entry.Title] {
+			continue
+		}
+		seen[match.Entry.Title] = true
+		result = append(result, match.Entry.Title)
+	}
+	return result
+}
@@ -1,10 +1,13 @@
 package site
 
 import (
+	"assets"
+	"authors"
 	"base"
 	"bufio"
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
@@ -12,14 +15,18 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"search"
 	"server"
+	"sort"
 	"state"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 )
 
@@ -43,6 +50,10 @@ type SiteTemplates struct {
 	Section     *template.Template
 	Entry       *template.Template
 	NotFound    *template.Template
+	Search      *template.Template
+	Author      *template.Template
+	Competition *template.Template
+	OpenSearch  *template.Template
 	Description *template.Template
 }
 
@@ -51,6 +62,13 @@ type Site struct {
 	State     *state.SiteState
 	Templates *SiteTemplates
 	Static    map[string]string
+	// Snapshot of State years that is used for rendering a single
+	// request.
+	Years []*base.Year
+	// Search and author indexes of the same or a newer snapshot of
+	// years.
+	Search  *search.Index
+	Authors *authors.Index
 }
 
 type YearlyNavigation struct {
@@ -74,17 +92,21 @@ type Prefetch struct {
 }
 
 type PageContext struct {
-	Path             string
-	Breadcrumbs      Breadcrumbs
-	Title            string
-	Description      string
-	SiteRoot         string
+	Path        string
+	Breadcrumbs Breadcrumbs
+	Title       string
+	Description string
+	SiteRoot    string
+	// OpenSearch description is only available with a configured
+	// base URL.
+	OpenSearch       bool
 	Static           map[string]string
 	CurrentYear      int
 	Prefetches       []Prefetch
 	SiteState        *state.SiteState
 	Navigation       PageNavigation
 	YearlyNavigation YearlyNavigation
+	SearchQuery      string
 }
 
 type GalleryThumbnails struct {
@@ -143,14 +165,53 @@ type SectionContext struct {
 	Section          SectionInfo
 	DisplayEntries   []*base.Entry
 	OffsetNavigation PageNavigation
-	Context          PageContext
+	// Link to all years of the same competition when the competition
+	// has been held in other years, too.
+	Competition InternalLink
+	Context     PageContext
+}
+
+type CompetitionContext struct {
+	Galleries []GalleryThumbnails
+	Context   PageContext
+}
+
+type RenderedAsset struct {
+	Id       string
+	Title    string
+	Contents string
 }
 
 type EntryContext struct {
-	Year    *base.Year
-	Section *base.Section
-	Entry   EntryInfo
-	Asset   string
+	Year        *base.Year
+	Section     *base.Section
+	Entry       EntryInfo
+	Asset       string
+	ExtraAssets []RenderedAsset
+	// All assets of the entry for navigation purposes.
+	Assets  []RenderedAsset
+	Context PageContext
+}
+
+type SearchSection struct {
+	Key  string
+	Name string
+}
+
+type SearchContext struct {
+	Query            string
+	Year             string
+	Section          string
+	Years            []*base.Year
+	Sections         []SearchSection
+	TotalResults     int
+	DisplayEntries   []*base.Entry
+	OffsetNavigation PageNavigation
+	Context          PageContext
+}
+
+type AuthorContext struct {
+	Author  *authors.Author
 	Context PageContext
 }
 
@@ -188,6 +249,20 @@ func struct_display_entries(row int, thumbnails []*base.Entry) DisplayEntries {
 	}
 }
 
+// Selects entries to preview a section. Top entries of ranked
+// sections are shown in their order and other sections get a random
+// selection.
+func select_preview_entries(section *base.Section) []*base.Entry {
+	if section.IsRanked && !section.IsOngoing {
+		preview_entries := MAX_PREVIEW_ENTRIES
+		if len(section.Entries) < preview_entries {
+			preview_entries = len(section.Entries)
+		}
+		return section.Entries[:preview_entries]
+	}
+	return random_select_section_entries(section, MAX_PREVIEW_ENTRIES)
+}
+
 func random_select_section_entries(section *base.Section, amount int) []*base.Entry {
 	section_indexes := rand.Perm(len(section.Entries))
 	max_items := len(section.Entries)
@@ -291,54 +366,48 @@ func view_attribute(name string, value string) string {
 }
 
 func view_image_srcset(images []base.ImageInfo) string {
-	type SrcSet struct {
-		Srcs  bytes.Buffer
-		Sizes bytes.Buffer
-	}
-	sets := map[string]*SrcSet{}
-	var last_set *SrcSet = nil
-	last_type := ""
-	for _, image := range images {
-		srcset := last_set
-		if image.Type != last_type {
-			var ok bool
-			srcset, ok = sets[image.Type]
-			if !ok {
-				srcset = &SrcSet{}
-				sets[image.Type] = srcset
-				srcset.Srcs.Grow(768)
-				srcset.Sizes.Grow(64)
-			} else {
-				srcset.Srcs.WriteString(", ")
-				srcset.Sizes.WriteString(", ")
-			}
-			last_set = srcset
-			last_type = image.Type
-		} else {
-			srcset.Srcs.WriteString(", ")
-			srcset.Sizes.WriteString(", ")
-		}
-		srcset.Srcs.WriteString(
-			html.EscapeString(strings.Replace(image.Path, " ", "%20", -1)))
-		srcset.Srcs.Write([]byte("?"))
-		srcset.Srcs.WriteString(html.EscapeString(image.Checksum))
-		srcset.Srcs.Write([]byte(" "))
-		size_x_str := []byte(strconv.Itoa(image.Size.X))
-		srcset.Srcs.Write(size_x_str)
-		srcset.Srcs.Write([]byte("w"))
-		srcset.Sizes.Write(size_x_str)
-		srcset.Sizes.Write([]byte("px"))
-	}
-	result := ""
-	for set_type, set_value := range sets {
-		result += fmt.Sprintf(
-			"<source type='%s' srcset='%s' sizes='%s' />",
-			set_type,
-			set_value.Srcs.String(),
-			set_value.Sizes.String(),
-		)
+	return assets.ImageSrcset(images)
+}
+
+func view_file_size(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
 	}
-	return result
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// Podium placements get badges on thumbnails.
+var MAX_BADGE_PLACEMENT = 3
+
+// Returns an English ordinal of a placement, like "1st" or "12th".
+func view_placement(placement int) string {
+	suffix := "th"
+	if placement%100 < 11 || placement%100 > 13 {
+		switch placement % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return fmt.Sprintf("%d%s", placement, suffix)
+}
+
+func view_points(points float64) string {
+	return strconv.FormatFloat(points, 'f', -1, 64)
+}
+
+func has_badge(ranking base.Ranking) bool {
+	return ranking.Placement > 0 && ranking.Placement <= MAX_BADGE_PLACEMENT
 }
 
 func add_prefetch_links(context *PageContext) {
@@ -400,9 +469,59 @@ func load_template(
 	return t_read, nil
 }
 
-func create_base_template(name string) *template.Template {
+// Renders author names of an entry with links to their author pages.
+// Each name is cut to the given maximum length.
+func view_author_links(site_root string, author string, max_length int) string {
+	var result bytes.Buffer
+	separators := authors.AUTHOR_SEPARATOR.FindAllString(author, -1)
+	for index, name := range authors.AUTHOR_SEPARATOR.Split(author, -1) {
+		if index > 0 {
+			result.WriteString(html.EscapeString(separators[index-1]))
+		}
+		display_name := html.EscapeString(view_cut_string(name, max_length))
+		key := authors.Key(name)
+		if key == "" {
+			result.WriteString(display_name)
+			continue
+		}
+		result.WriteString(fmt.Sprintf(
+			"<a href=\"%s/author/%s\">%s</a>",
+			html.EscapeString(site_root),
+			key,
+			display_name))
+	}
+	return result.String()
+}
+
+// Renders a credited group or person with a link to their author
+// page and their roles, like "Purple Motion (music)".
+func view_credit(site_root string, credit base.Credit) string {
+	result := html.EscapeString(credit.Name)
+	if key := authors.Key(credit.Name); key != "" {
+		result = fmt.Sprintf(
+			"<a href=\"%s/author/%s\">%s</a>",
+			html.EscapeString(site_root),
+			key,
+			result)
+	}
+	if len(credit.Roles) > 0 {
+		result += fmt.Sprintf(
+			" <span class=\"credit-roles\">(%s)</span>",
+			html.EscapeString(strings.Join(credit.Roles, ", ")))
+	}
+	return result
+}
+
+func create_base_template(
+	name string, settings *base.SiteSettings) *template.Template {
 	t := template.New(name)
 	functions := template.FuncMap{}
+	functions["view_author_links"] = func(author string, max_length int) string {
+		return view_author_links(settings.SiteRoot, author, max_length)
+	}
+	functions["view_credit"] = func(credit base.Credit) string {
+		return view_credit(settings.SiteRoot, credit)
+	}
 	functions["view_author_title"] = view_author_title
 	functions["view_cut_string"] = view_cut_string
 	functions["view_attribute"] = view_attribute
@@ -411,6 +530,10 @@ func create_base_template(name string) *template.Template {
 	functions["view_get_image_data_src"] = view_get_image_data_src
 	functions["struct_display_entries"] = struct_display_entries
 	functions["view_image_srcset"] = view_image_srcset
+	functions["view_file_size"] = view_file_size
+	functions["view_placement"] = view_placement
+	functions["view_points"] = view_points
+	functions["has_badge"] = has_badge
 	return t.Funcs(functions)
 }
 
@@ -418,22 +541,37 @@ func load_templates(settings *base.SiteSettings) (SiteTemplates, error) {
 	var templates SiteTemplates
 	data := "asdf"
 
-	generic := create_base_template("generic")
+	generic := create_base_template("generic", settings)
 
 	var err error
 	{
-		generic = template.Must(
-			load_template(settings, "thumbnails", "thumbnails.html.tmpl", generic))
-		generic = template.Must(
-			load_template(settings, "breadcrumbs", "breadcrumbs.html.tmpl", generic))
-		generic = template.Must(
-			load_template(settings, "navbar", "navbar.html.tmpl", generic))
-		generic = template.Must(
-			load_template(settings, "yearlynavigation", "yearlynavigation.html.tmpl", generic))
+		generic, err = load_template(
+			settings, "thumbnails", "thumbnails.html.tmpl", generic)
+		if err != nil {
+			return templates, err
+		}
+		generic, err = load_template(
+			settings, "breadcrumbs", "breadcrumbs.html.tmpl", generic)
+		if err != nil {
+			return templates, err
+		}
+		generic, err = load_template(
+			settings, "navbar", "navbar.html.tmpl", generic)
+		if err != nil {
+			return templates, err
+		}
+		generic, err = load_template(
+			settings, "yearlynavigation", "yearlynavigation.html.tmpl", generic)
+		if err != nil {
+			return templates, err
+		}
 	}
 	{
-		contents := template.Must(
-			load_template(settings, "page-contents", "main.html.tmpl", generic))
+		contents, err_contents := load_template(
+			settings, "page-contents", "main.html.tmpl", generic)
+		if err_contents != nil {
+			return templates, err_contents
+		}
 		templates.Main, err = load_template(
 			settings, "main", "layout.html.tmpl", contents)
 		if err != nil {
@@ -441,8 +579,11 @@ func load_templates(settings *base.SiteSettings) (SiteTemplates, error) {
 		}
 	}
 	{
-		contents := template.Must(
-			load_template(settings, "page-contents", "year.html.tmpl", generic))
+		contents, err_contents := load_template(
+			settings, "page-contents", "year.html.tmpl", generic)
+		if err_contents != nil {
+			return templates, err_contents
+		}
 		templates.Year, err = load_template(
 			settings, "year", "layout.html.tmpl", contents)
 		if err != nil {
@@ -450,8 +591,11 @@ func load_templates(settings *base.SiteSettings) (SiteTemplates, error) {
 		}
 	}
 	{
-		contents := template.Must(
-			load_template(settings, "page-contents", "section.html.tmpl", generic))
+		contents, err_contents := load_template(
+			settings, "page-contents", "section.html.tmpl", generic)
+		if err_contents != nil {
+			return templates, err_contents
+		}
 		templates.Section, err = load_template(
 			settings, "section", "layout.html.tmpl", contents)
 		if err != nil {
@@ -459,8 +603,11 @@ func load_templates(settings *base.SiteSettings) (SiteTemplates, error) {
 		}
 	}
 	{
-		contents := template.Must(
-			load_template(settings, "page-contents", "entry.html.tmpl", generic))
+		contents, err_contents := load_template(
+			settings, "page-contents", "entry.html.tmpl", generic)
+		if err_contents != nil {
+			return templates, err_contents
+		}
 		templates.Entry, err = load_template(
 			settings, "entry", "layout.html.tmpl", contents)
 		if err != nil {
@@ -468,14 +615,60 @@ func load_templates(settings *base.SiteSettings) (SiteTemplates, error) {
 		}
 	}
 	{
-		contents := template.Must(
-			load_template(settings, "page-contents", "404.html.tmpl", generic))
+		contents, err_contents := load_template(
+			settings, "page-contents", "404.html.tmpl", generic)
+		if err_contents != nil {
+			return templates, err_contents
+		}
 		templates.NotFound, err = load_template(
 			settings, "404", "layout.html.tmpl", contents)
 		if err != nil {
 			return templates, err
 		}
 	}
+	{
+		contents, err_contents := load_template(
+			settings, "page-contents", "search.html.tmpl", generic)
+		if err_contents != nil {
+			return templates, err_contents
+		}
+		templates.Search, err = load_template(
+			settings, "search", "layout.html.tmpl", contents)
+		if err != nil {
+			return templates, err
+		}
+	}
+	{
+		contents, err_contents := load_template(
+			settings, "page-contents", "competition.html.tmpl", generic)
+		if err_contents != nil {
+			return templates, err_contents
+		}
+		templates.Competition, err = load_template(
+			settings, "competition", "layout.html.tmpl", contents)
+		if err != nil {
+			return templates, err
+		}
+	}
+	{
+		contents, err_contents := load_template(
+			settings, "page-contents", "author.html.tmpl", generic)
+		if err_contents != nil {
+			return templates, err_contents
+		}
+		templates.Author, err = load_template(
+			settings, "author", "layout.html.tmpl", contents)
+		if err != nil {
+			return templates, err
+		}
+	}
+	{
+		templates.OpenSearch, err = load_template(
+			settings, "opensearch", "opensearch.xml.tmpl", nil)
+		if err != nil {
+			return templates, err
+		}
+	}
 	{
 		t := template.New("description")
 		templates.Description = template.Must(t.Parse(data))
@@ -558,53 +751,16 @@ type RequestHandlerFunc func(
 	w http.ResponseWriter,
 	r *http.Request)
 
-type AssetHandler func(site Site, entry base.Entry) string
-
-var ASSET_HANDLERS = map[string]AssetHandler{
-	"youtube": handle_asset_youtube,
-	"image":   handle_asset_image,
-}
-
-func handle_asset_youtube(site Site, entry base.Entry) string {
-	youtube := entry.Asset.Data.(state.YoutubeAsset)
-	EMBED_TEMPLATE := `<iframe id="ytplayerembed" class="youtube-player" width="%d" height="%d" src="https://www.youtube.com/embed/%s" style="border: 0px" allowfullscreen="allowfullscreen">\n</iframe>`
-	CONTROLS_HEIGHT := 0.0
-	ASPECT_RATIO := 16.0 / 9.0
-	DEFAULT_WIDTH := 640
-	width := DEFAULT_WIDTH
-	height := int(float64(width)/ASPECT_RATIO + CONTROLS_HEIGHT)
-	embed_id := youtube.Id
-	if strings.Contains(youtube.Id, "#t=") {
-		splits := strings.SplitN(youtube.Id, "#t=", 2)
-		id := splits[0]
-		timestamp := splits[1]
-		embed_id = fmt.Sprintf("%s?start=%s", id, timestamp)
-	}
-	return fmt.Sprintf(EMBED_TEMPLATE, width, height, html.EscapeString(embed_id))
-}
-
-func handle_asset_image(site Site, entry base.Entry) string {
-	image := entry.Asset.Data.(state.ImageAsset)
-	EMBED_TEMPLATE := `
-<picture>
-    %s
-    <img src="%s" alt="%s" title="%s" width="%d" height="%d" />
-</picture>
-`
-	image_path := fmt.Sprintf(
-		"%s?%s",
-		image.Default.Path,
-		image.Default.Checksum)
-	image_author_title := author_title(entry)
-	return fmt.Sprintf(
-		EMBED_TEMPLATE,
-		view_image_srcset(image.Sources),
-		html.EscapeString(image_path),
-		html.EscapeString(image_author_title),
-		html.EscapeString(image_author_title),
-		image.Default.Size.X,
-		image.Default.Size.Y,
-	)
+// Asset navigation title of an asset. Assets without their own title
+// use the default title of their asset type.
+func get_asset_title(asset base.Asset) string {
+	if asset.Title != "" {
+		return asset.Title
+	}
+	if asset_type, ok := assets.Get(asset.Type); ok {
+		return asset_type.DefaultTitle()
+	}
+	return asset.Type
 }
 
 func handle_entry(
@@ -620,10 +776,11 @@ func handle_entry(
 	}
 
 	page_context := PageContext{
-		Path:     path_elements[""],
-		Title:    author_title(entry.Curr),
-		SiteRoot: site.Settings.SiteRoot,
-		Static:   site.Static,
+		Path:       path_elements[""],
+		Title:      author_title(entry.Curr),
+		SiteRoot:   site.Settings.SiteRoot,
+		OpenSearch: site.Settings.BaseUrl != "",
+		Static:     site.Static,
 		Breadcrumbs: Breadcrumbs{
 			Parents: []InternalLink{
 				InternalLink{
@@ -653,21 +810,41 @@ func handle_entry(
 		},
 	}
 
-	asset_handler, ok := ASSET_HANDLERS[entry.Curr.Asset.Type]
-	if !ok {
-		server.Ise(w)
-		log.Printf(
-			"No handler on %s for asset type %s",
-			entry.Curr.Path,
-			entry.Curr.Asset.Type)
-		return
+	entry_assets := append(
+		[]base.Asset{entry.Curr.Asset}, entry.Curr.ExtraAssets...)
+	asset_context := assets.RenderContext{
+		Entry:       entry.Curr,
+		AuthorTitle: author_title(entry.Curr),
+	}
+	var rendered_assets []RenderedAsset
+	for index, asset := range entry_assets {
+		asset_type, ok := assets.Get(asset.Type)
+		if !ok {
+			server.Ise(w)
+			log.Printf(
+				"No handler on %s for asset type %s",
+				entry.Curr.Path,
+				asset.Type)
+			return
+		}
+		id := "gallery-item"
+		if index > 0 {
+			id = fmt.Sprintf("asset-%d", index)
+		}
+		rendered_assets = append(rendered_assets, RenderedAsset{
+			Id:       id,
+			Title:    get_asset_title(asset),
+			Contents: asset_type.Render(asset_context, asset.Data),
+		})
 	}
 	context := EntryContext{
-		Year:    entry.Year,
-		Section: entry.Section,
-		Entry:   entry,
-		Asset:   asset_handler(site, entry.Curr),
-		Context: page_context,
+		Year:        entry.Year,
+		Section:     entry.Section,
+		Entry:       entry,
+		Asset:       rendered_assets[0].Contents,
+		ExtraAssets: rendered_assets[1:],
+		Assets:      rendered_assets,
+		Context:     page_context,
 	}
 	add_prefetch_links(&context.Context)
 	add_cache_time(w, CACHE_TIME_STATIC_PAGE_S)
@@ -751,8 +928,9 @@ func handle_section(
 			"Entries for year %d section %s",
 			section.Year.Year,
 			section.Curr.Name),
-		SiteRoot: site.Settings.SiteRoot,
-		Static:   site.Static,
+		SiteRoot:   site.Settings.SiteRoot,
+		OpenSearch: site.Settings.BaseUrl != "",
+		Static:     site.Static,
 		Breadcrumbs: Breadcrumbs{
 			Parents: []InternalLink{
 				InternalLink{
@@ -787,6 +965,14 @@ func handle_section(
 		Section:          section,
 		Context:          page_context,
 	}
+	family := section_family(&section.Curr)
+	if len(get_family_sections(site, family)) > 1 {
+		context.Competition = InternalLink{
+			Path:     competition_path(site, family),
+			Contents: "All years",
+			Title:    fmt.Sprintf("%s from all years", section.Curr.Name),
+		}
+	}
 	add_prefetch_links(&context.Context)
 	add_cache_time(w, CACHE_TIME_STATIC_PAGE_S)
 	err_template := render_template(w, site.Templates.Section, context)
@@ -807,7 +993,7 @@ func get_year_info(site Site, path_elements map[string]string) (YearInfo, error)
 				year_err))
 	}
 	last_index := 0
-	for i, candidate_year := range site.State.Years {
+	for i, candidate_year := range site.Years {
 		last_index = i
 		if candidate_year.Year == requested_year {
 			info.Curr = *candidate_year
@@ -819,8 +1005,8 @@ func get_year_info(site Site, path_elements map[string]string) (YearInfo, error)
 		return info, errors.New(
 			fmt.Sprintf("Year %s not found!", path_elements["Year"]))
 	}
-	if last_index+1 < len(site.State.Years) {
-		info.Prev = *site.State.Years[last_index+1]
+	if last_index+1 < len(site.Years) {
+		info.Prev = *site.Years[last_index+1]
 	}
 	return info, nil
 }
@@ -881,12 +1067,12 @@ func get_entry_info(site Site, path_elements map[string]string) (EntryInfo, erro
 }
 
 func get_yearly_navigation(site Site, current_year int) YearlyNavigation {
-	if len(site.State.Years) == 0 {
+	if len(site.Years) == 0 {
 		return YearlyNavigation{}
 	}
-	year_max := site.State.Years[0].Year
-	year_min := site.State.Years[len(site.State.Years)-1].Year
-	years_count := len(site.State.Years)
+	year_max := site.Years[0].Year
+	year_min := site.Years[len(site.Years)-1].Year
+	years_count := len(site.Years)
 	highlighted_index := -1
 	if current_year < year_min {
 		highlighted_index = -1
@@ -920,9 +1106,9 @@ func get_yearly_navigation(site Site, current_year int) YearlyNavigation {
 	var display_years []InternalLink
 	if 0 < index_first {
 		laquo := InternalLink{
-			Path:     site.State.Years[index_first-1].Path,
+			Path:     site.Years[index_first-1].Path,
 			Contents: "«",
-			Title:    site.State.Years[index_first-1].Key,
+			Title:    site.Years[index_first-1].Key,
 		}
 		display_years = append(display_years, laquo)
 	}
@@ -930,9 +1116,9 @@ func get_yearly_navigation(site Site, current_year int) YearlyNavigation {
 	current_index := -1
 	for i := index_first; i < index_last; i++ {
 		year_link := InternalLink{
-			Path:     site.State.Years[i].Path,
-			Contents: fmt.Sprintf("'%02d", (site.State.Years[i].Year % 100)),
-			Title:    site.State.Years[i].Key,
+			Path:     site.Years[i].Path,
+			Contents: fmt.Sprintf("'%02d", (site.Years[i].Year % 100)),
+			Title:    site.Years[i].Key,
 		}
 		if i == highlighted_index {
 			current_index = len(display_years)
@@ -942,9 +1128,9 @@ func get_yearly_navigation(site Site, current_year int) YearlyNavigation {
 
 	if index_last < years_count {
 		raquo := InternalLink{
-			Path:     site.State.Years[index_last].Path,
+			Path:     site.Years[index_last].Path,
 			Contents: "»",
-			Title:    site.State.Years[index_last].Key,
+			Title:    site.Years[index_last].Key,
 		}
 		display_years = append(display_years, raquo)
 	}
@@ -973,8 +1159,9 @@ func handle_year(
 		Description: fmt.Sprintf(
 			"Competitions and other events for %d Assembly parties",
 			year.Curr.Year),
-		SiteRoot: site.Settings.SiteRoot,
-		Static:   site.Static,
+		SiteRoot:   site.Settings.SiteRoot,
+		OpenSearch: site.Settings.BaseUrl != "",
+		Static:     site.Static,
 		Breadcrumbs: Breadcrumbs{
 			Last: InternalLink{
 				Path:     year.Curr.Path,
@@ -997,20 +1184,10 @@ func handle_year(
 
 	gallery_thumbnails := make([]GalleryThumbnails, len(year.Curr.Sections))
 	for i, section := range year.Curr.Sections {
-		var display_entries []*base.Entry
-		if section.IsRanked && !section.IsOngoing {
-			preview_entries := MAX_PREVIEW_ENTRIES
-			if len(section.Entries) < preview_entries {
-				preview_entries = len(section.Entries)
-			}
-			display_entries = section.Entries[:preview_entries]
-		} else {
-			display_entries = random_select_section_entries(section, MAX_PREVIEW_ENTRIES)
-		}
 		thumbnails := GalleryThumbnails{
 			Path:    section.Path,
 			Title:   section.Name,
-			Entries: display_entries,
+			Entries: select_preview_entries(section),
 		}
 		gallery_thumbnails[i] = thumbnails
 	}
@@ -1068,9 +1245,9 @@ func _read_year_range(
 		if err == nil {
 			year_end = year_start + DEFAULT_MAIN_YEARS
 		}
-	} else if len(site.State.Years) > 0 {
+	} else if len(site.Years) > 0 {
 		// Years array is sorted in the reverse order.
-		year_end = site.State.Years[0].Year
+		year_end = site.Years[0].Year
 		year_start = year_end - DEFAULT_MAIN_YEARS
 	}
 	max_year := year_end + 1 + DEFAULT_MAIN_YEARS
@@ -1079,7 +1256,7 @@ func _read_year_range(
 	var years_before []*base.Year
 	var years []*base.Year
 	var years_after []*base.Year
-	for _, year := range site.State.Years {
+	for _, year := range site.Years {
 		if max_year < year.Year {
 			continue
 		}
@@ -1096,8 +1273,8 @@ func _read_year_range(
 	}
 
 	var latest_year *base.Year
-	if len(site.State.Years) > 0 {
-		latest_year = site.State.Years[0]
+	if len(site.Years) > 0 {
+		latest_year = site.Years[0]
 	}
 	link_before := _create_year_range_link(site, years_before, latest_year)
 	link_after := _create_year_range_link(site, years_after, latest_year)
@@ -1135,6 +1312,7 @@ func handle_main(
 		Path:        path_elements[""],
 		Description: MAIN_DESCRIPTION,
 		SiteRoot:    site.Settings.SiteRoot,
+		OpenSearch:  site.Settings.BaseUrl != "",
 		Static:      site.Static,
 		Navigation: PageNavigation{
 			Prev: *years_before,
@@ -1163,6 +1341,320 @@ func handle_main(
 	}
 }
 
+// Lists sections of all years for the search section filter. Sections
+// with the same key are shown once with their latest name.
+func get_search_sections(years []*base.Year) []SearchSection {
+	var result []SearchSection
+	seen := make(map[string]bool)
+	for _, year := range years {
+		for _, section := range year.Sections {
+			if seen[section.Key] {
+				continue
+			}
+			seen[section.Key] = true
+			result = append(result, SearchSection{
+				Key:  section.Key,
+				Name: section.Name,
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func search_page_path(site Site, query search.Query, offset int) string {
+	values := url.Values{}
+	values.Set("q", query.Text)
+	if query.Year != "" {
+		values.Set("year", query.Year)
+	}
+	if query.Section != "" {
+		values.Set("section", query.Section)
+	}
+	if offset > 0 {
+		values.Set("offset", strconv.Itoa(offset))
+	}
+	return site.Settings.SiteRoot + "/search?" + values.Encode()
+}
+
+func handle_search(
+	site Site,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request) {
+	query := search.Query{
+		Text:    strings.TrimSpace(r.FormValue("q")),
+		Year:    r.FormValue("year"),
+		Section: r.FormValue("section"),
+	}
+	offset, err_offset := strconv.Atoi(r.FormValue("offset"))
+	if err_offset != nil || offset < 0 {
+		offset = 0
+	}
+
+	var results []search.Result
+	if query.Text != "" && site.Search != nil {
+		results = site.Search.Search(query)
+	}
+	var display_entries []*base.Entry
+	for index := offset; index < len(results); index++ {
+		if len(display_entries) == MAX_SECTION_DISPLAY_ENTRIES {
+			break
+		}
+		display_entries = append(display_entries, results[index].Entry)
+	}
+	var offset_navigation PageNavigation
+	if offset > 0 {
+		prev_offset := offset - MAX_SECTION_DISPLAY_ENTRIES
+		if prev_offset < 0 {
+			prev_offset = 0
+		}
+		offset_navigation.Next = InternalLink{
+			Contents: "Previous " + strconv.Itoa(MAX_SECTION_DISPLAY_ENTRIES) + " results",
+			Path:     search_page_path(site, query, prev_offset),
+		}
+	}
+	next_offset := offset + MAX_SECTION_DISPLAY_ENTRIES
+	if next_offset < len(results) {
+		next_results_count := len(results) - next_offset
+		if MAX_SECTION_DISPLAY_ENTRIES < next_results_count {
+			next_results_count = MAX_SECTION_DISPLAY_ENTRIES
+		}
+		offset_navigation.Prev = InternalLink{
+			Contents: "Next " + strconv.Itoa(next_results_count) + " results",
+			Path:     search_page_path(site, query, next_offset),
+		}
+	}
+
+	title := "Search"
+	if query.Text != "" {
+		title = "Search: " + query.Text
+	}
+	page_context := PageContext{
+		Path:        path_elements[""],
+		Title:       title,
+		Description: "Search entries by title, author, and description",
+		SiteRoot:    site.Settings.SiteRoot,
+		OpenSearch:  site.Settings.BaseUrl != "",
+		Static:      site.Static,
+		Breadcrumbs: Breadcrumbs{
+			Last: InternalLink{
+				Path:     site.Settings.SiteRoot + "/search",
+				Contents: "Search",
+			},
+		},
+		YearlyNavigation: get_yearly_navigation(site, 0),
+		SearchQuery:      query.Text,
+	}
+	context := SearchContext{
+		Query:            query.Text,
+		Year:             query.Year,
+		Section:          query.Section,
+		Years:            site.Years,
+		Sections:         get_search_sections(site.Years),
+		TotalResults:     len(results),
+		DisplayEntries:   display_entries,
+		OffsetNavigation: offset_navigation,
+		Context:          page_context,
+	}
+	add_cache_time(w, CACHE_TIME_DYNAMIC_PAGE_S)
+	err_template := render_template(w, site.Templates.Search, context)
+	if err_template != nil {
+		server.Ise(w)
+		log.Printf("Internal search page error: %s", err_template)
+	}
+}
+
+// Sections are grouped across years by their declared competition
+// family or by their key.
+func section_family(section *base.Section) string {
+	if section.Family != "" {
+		return section.Family
+	}
+	return section.Key
+}
+
+func competition_path(site Site, family string) string {
+	return site.Settings.SiteRoot + "/competition/" + family
+}
+
+type family_section struct {
+	Year    *base.Year
+	Section *base.Section
+}
+
+// Returns sections that belong to the given competition family,
+// newest years first.
+func get_family_sections(site Site, family string) []family_section {
+	var result []family_section
+	for _, year := range site.Years {
+		for _, section := range year.Sections {
+			if section_family(section) == family {
+				result = append(
+					result, family_section{Year: year, Section: section})
+			}
+		}
+	}
+	return result
+}
+
+func handle_competition(
+	site Site,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request) {
+	family := path_elements["Family"]
+	sections := get_family_sections(site, family)
+	if len(sections) == 0 {
+		handle_not_found(site, w, r)
+		return
+	}
+	// Latest name of the competition is the most recognizable one.
+	title := sections[0].Section.Name
+	galleries := make([]GalleryThumbnails, len(sections))
+	for i, section := range sections {
+		galleries[i] = GalleryThumbnails{
+			Path: section.Section.Path,
+			Title: fmt.Sprintf(
+				"%s %s", section.Year.Key, section.Section.Name),
+			Entries: select_preview_entries(section.Section),
+		}
+	}
+	page_context := PageContext{
+		Path:  path_elements[""],
+		Title: title,
+		Description: fmt.Sprintf(
+			"%s competitions at Assembly parties from %s to %s",
+			title,
+			sections[len(sections)-1].Year.Key,
+			sections[0].Year.Key),
+		SiteRoot:   site.Settings.SiteRoot,
+		OpenSearch: site.Settings.BaseUrl != "",
+		Static:     site.Static,
+		Breadcrumbs: Breadcrumbs{
+			Last: InternalLink{
+				Path:     competition_path(site, family),
+				Contents: title,
+				Title:    title,
+			},
+		},
+		YearlyNavigation: get_yearly_navigation(site, 0),
+	}
+	context := CompetitionContext{
+		Galleries: galleries,
+		Context:   page_context,
+	}
+	add_cache_time(w, CACHE_TIME_DYNAMIC_PAGE_S)
+	err_template := render_template(w, site.Templates.Competition, context)
+	if err_template != nil {
+		server.Ise(w)
+		log.Printf("Internal competition page error: %s", err_template)
+	}
+}
+
+func handle_author(
+	site Site,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request) {
+	var author *authors.Author
+	if site.Authors != nil {
+		author = site.Authors.Get(path_elements["Author"])
+	}
+	if author == nil {
+		handle_not_found(site, w, r)
+		return
+	}
+	entries_text := "entries"
+	if author.EntryCount == 1 {
+		entries_text = "entry"
+	}
+	page_context := PageContext{
+		Path:  path_elements[""],
+		Title: author.Name,
+		Description: fmt.Sprintf(
+			"%d %s by %s", author.EntryCount, entries_text, author.Name),
+		SiteRoot:   site.Settings.SiteRoot,
+		OpenSearch: site.Settings.BaseUrl != "",
+		Static:     site.Static,
+		Breadcrumbs: Breadcrumbs{
+			Last: InternalLink{
+				Path:     site.Settings.SiteRoot + "/author/" + author.Key,
+				Contents: author.Name,
+				Title:    author.Name,
+			},
+		},
+		YearlyNavigation: get_yearly_navigation(site, 0),
+	}
+	context := AuthorContext{
+		Author:  author,
+		Context: page_context,
+	}
+	add_cache_time(w, CACHE_TIME_STATIC_PAGE_S)
+	err_template := render_template(w, site.Templates.Author, context)
+	if err_template != nil {
+		server.Ise(w)
+		log.Printf("Internal author page error: %s", err_template)
+	}
+}
+
+var MAX_SEARCH_SUGGESTIONS = 10
+
+type OpenSearchContext struct {
+	BaseUrl string
+	Static  map[string]string
+}
+
+// OpenSearch description needs absolute URLs. They are only taken
+// from the configured base URL, as request headers are controlled by
+// clients and the description is cached.
+func handle_opensearch(
+	site Site,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request) {
+	if site.Settings.BaseUrl == "" {
+		handle_not_found(site, w, r)
+		return
+	}
+	context := OpenSearchContext{
+		BaseUrl: site.Settings.BaseUrl,
+		Static:  site.Static,
+	}
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	add_cache_time(w, CACHE_TIME_STATIC_PAGE_S)
+	err_template := render_template(w, site.Templates.OpenSearch, context)
+	if err_template != nil {
+		server.Ise(w)
+		log.Printf("Internal OpenSearch document error: %s", err_template)
+	}
+}
+
+// Search suggestions in the OpenSearch suggestions format, where the
+// response is [query, [suggestion, ...]].
+func handle_search_suggestions(
+	site Site,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request) {
+	query := strings.TrimSpace(r.FormValue("q"))
+	suggestions := []string{}
+	if query != "" && site.Search != nil {
+		suggestions = site.Search.Suggest(query, MAX_SEARCH_SUGGESTIONS)
+	}
+	data, err_json := json.Marshal([]interface{}{query, suggestions})
+	if err_json != nil {
+		server.Ise(w)
+		log.Printf("Search suggestion error: %s", err_json)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-suggestions+json")
+	add_cache_time(w, CACHE_TIME_DYNAMIC_PAGE_S)
+	w.Write(data)
+}
+
 type RequestHandler struct {
 	regex    *regexp.Regexp
 	callback RequestHandlerFunc
@@ -1173,6 +1665,12 @@ var HANDLERS = []RequestHandler{
 		handle_entry},
 	{regexp.MustCompile(`^(?P<Year>\d{4})/(?P<Section>[a-z0-9\-]+)/?$`), handle_section},
 	{regexp.MustCompile(`^(?P<Year>\d{4})/?$`), handle_year},
+	{regexp.MustCompile(`^competition/(?P<Family>[a-z0-9\-]+)/?$`),
+		handle_competition},
+	{regexp.MustCompile(`^author/(?P<Author>[a-z0-9\-]+)/?$`), handle_author},
+	{regexp.MustCompile(`^search/?$`), handle_search},
+	{regexp.MustCompile(`^search/suggest/?$`), handle_search_suggestions},
+	{regexp.MustCompile(`^opensearch\.xml$`), handle_opensearch},
 	{regexp.MustCompile("^$"), handle_main},
 }
 
@@ -1186,6 +1684,7 @@ func handle_not_found(
 		Title:            "404 page not found",
 		Description:      "404 page not found",
 		SiteRoot:         site.Settings.SiteRoot,
+		OpenSearch:       site.Settings.BaseUrl != "",
 		Static:           site.Static,
 		YearlyNavigation: get_yearly_navigation(site, 0),
 	}
@@ -1228,28 +1727,88 @@ func route_request(
 	}
 }
 
-func SiteRenderer(settings base.SiteSettings, state *state.SiteState) http.HandlerFunc {
+// Templates and static file checksums that are read from the disk
+// and can be replaced while the site is running.
+type SiteResources struct {
+	Templates *SiteTemplates
+	Static    map[string]string
+}
+
+func LoadResources(settings base.SiteSettings) (SiteResources, error) {
 	templates, err_templates := load_templates(&settings)
 	if err_templates != nil {
-		log.Println(err_templates)
-		panic("Unable to load templates!")
+		return SiteResources{}, fmt.Errorf(
+			"Unable to load templates: %s", err_templates)
 	}
-
 	static, err_static := load_static_files(&settings)
 	if err_static != nil {
-		log.Println(err_static)
-		panic("Unable to load static files!")
+		return SiteResources{}, fmt.Errorf(
+			"Unable to load static files: %s", err_static)
 	}
-
-	site := Site{
-		Settings:  settings,
-		State:     state,
+	return SiteResources{
 		Templates: &templates,
 		Static:    static,
-	}
+	}, nil
+}
+
+// Renders site pages. Every request is rendered with one consistent
+// version of site state and resources.
+type Renderer struct {
+	settings  base.SiteSettings
+	state     *state.SiteState
+	lock      sync.RWMutex
+	resources SiteResources
+	search    *search.Index
+	authors   *authors.Index
+}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", "text/html")
-		route_request(site, w, r)
+func (renderer *Renderer) replace_indexes(years []*base.Year) {
+	search_index := search.New(years)
+	authors_index := authors.New(years)
+	renderer.lock.Lock()
+	defer renderer.lock.Unlock()
+	renderer.search = search_index
+	renderer.authors = authors_index
+}
+
+func (renderer *Renderer) ReplaceResources(resources SiteResources) {
+	renderer.lock.Lock()
+	defer renderer.lock.Unlock()
+	renderer.resources = resources
+}
+
+func (renderer *Renderer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	renderer.lock.RLock()
+	resources := renderer.resources
+	search_index := renderer.search
+	authors_index := renderer.authors
+	renderer.lock.RUnlock()
+	site := Site{
+		Settings:  renderer.settings,
+		State:     renderer.state,
+		Templates: resources.Templates,
+		Static:    resources.Static,
+		Years:     renderer.state.Years(),
+		Search:    search_index,
+		Authors:   authors_index,
+	}
+	w.Header().Add("Content-Type", "text/html")
+	route_request(site, w, r)
+}
+
+func NewRenderer(
+	settings base.SiteSettings, state *state.SiteState) (*Renderer, error) {
+	resources, err := LoadResources(settings)
+	if err != nil {
+		return nil, err
+	}
+	renderer := Renderer{
+		settings:  settings,
+		state:     state,
+		resources: resources,
 	}
+	// Search and author indexes are rebuilt every time the API or a
+	// reload replaces years or sections.
+	state.Subscribe(renderer.replace_indexes)
+	return &renderer, nil
 }
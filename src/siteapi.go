@@ -0,0 +1,507 @@
+package siteapi
+
+import (
+	"assets"
+	"authors"
+	"base"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"state"
+	"strconv"
+)
+
+// Version of the read-only API. Incompatible changes to the returned
+// documents require a new version that is served next to the old one.
+var API_VERSION = 1
+
+var MAX_SECTION_ENTRIES = 30
+
+var CACHE_TIME_YEARS_S = 30
+var CACHE_TIME_DOCUMENT_S = 120
+
+type ApiSite struct {
+	Settings base.SiteSettings
+	// Snapshot of site state years that is used for a single request.
+	Years []*base.Year
+}
+
+type YearSummaryJson struct {
+	Key     string `json:"key"`
+	Year    int    `json:"year"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	ApiPath string `json:"api-path"`
+}
+
+type YearsJson struct {
+	Version int               `json:"version"`
+	Years   []YearSummaryJson `json:"years"`
+}
+
+type SectionSummaryJson struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	IsRanked    bool   `json:"is-ranked"`
+	IsOngoing   bool   `json:"is-ongoing"`
+	Family      string `json:"family,omitempty"`
+	Path        string `json:"path"`
+	ApiPath     string `json:"api-path"`
+	EntryCount  int    `json:"entry-count"`
+}
+
+type YearJson struct {
+	Version int `json:"version"`
+	YearSummaryJson
+	Sections []SectionSummaryJson `json:"sections"`
+}
+
+type ThumbnailsJson struct {
+	Default assets.ImageJson   `json:"default"`
+	Sources []assets.ImageJson `json:"sources"`
+}
+
+type EntrySummaryJson struct {
+	Key         string         `json:"key"`
+	Title       string         `json:"title"`
+	Author      string         `json:"author"`
+	Placement   int            `json:"placement,omitempty"`
+	Points      *float64       `json:"points,omitempty"`
+	SharedPlace bool           `json:"shared-place,omitempty"`
+	Path        string         `json:"path"`
+	ApiPath     string         `json:"api-path"`
+	Thumbnails  ThumbnailsJson `json:"thumbnails"`
+}
+
+type PaginationJson struct {
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+	Total  int    `json:"total"`
+	Prev   string `json:"prev,omitempty"`
+	Next   string `json:"next,omitempty"`
+}
+
+type SectionJson struct {
+	Version int             `json:"version"`
+	Year    YearSummaryJson `json:"year"`
+	SectionSummaryJson
+	Entries    []EntrySummaryJson `json:"entries"`
+	Pagination PaginationJson     `json:"pagination"`
+}
+
+type AssetJson struct {
+	Type  string      `json:"type"`
+	Title string      `json:"title,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+type DownloadFileJson struct {
+	Filename string `json:"filename"`
+	Url      string `json:"url"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+	Platform string `json:"platform,omitempty"`
+}
+
+type CreditJson struct {
+	Name    string       `json:"name"`
+	Key     string       `json:"key"`
+	Aliases []string     `json:"aliases,omitempty"`
+	Roles   []string     `json:"roles,omitempty"`
+	Members []CreditJson `json:"members,omitempty"`
+}
+
+type CreditsJson struct {
+	Groups  []CreditJson `json:"groups"`
+	Persons []CreditJson `json:"persons"`
+}
+
+type EntryJson struct {
+	Version int                `json:"version"`
+	Year    YearSummaryJson    `json:"year"`
+	Section SectionSummaryJson `json:"section"`
+	EntrySummaryJson
+	Description   string                      `json:"description"`
+	Credits       CreditsJson                 `json:"credits"`
+	Assets        []AssetJson                 `json:"assets"`
+	ExternalLinks []base.ExternalLinksSection `json:"external-links"`
+	Files         []DownloadFileJson          `json:"files"`
+}
+
+func api_path(site ApiSite, parts ...string) string {
+	result := fmt.Sprintf("%s/_api/v%d", site.Settings.SiteRoot, API_VERSION)
+	for _, part := range parts {
+		result += "/" + part
+	}
+	return result
+}
+
+func encode_year_summary(site ApiSite, year *base.Year) YearSummaryJson {
+	return YearSummaryJson{
+		Key:     year.Key,
+		Year:    year.Year,
+		Name:    year.Name,
+		Path:    year.Path,
+		ApiPath: api_path(site, year.Key),
+	}
+}
+
+func encode_section_summary(
+	site ApiSite, year *base.Year, section *base.Section) SectionSummaryJson {
+	return SectionSummaryJson{
+		Key:         section.Key,
+		Name:        section.Name,
+		Description: section.Description,
+		IsRanked:    section.IsRanked,
+		IsOngoing:   section.IsOngoing,
+		Family:      section.Family,
+		Path:        section.Path,
+		ApiPath:     api_path(site, year.Key, section.Key),
+		EntryCount:  len(section.Entries),
+	}
+}
+
+func encode_entry_summary(
+	site ApiSite,
+	year *base.Year,
+	section *base.Section,
+	entry *base.Entry) EntrySummaryJson {
+	result := EntrySummaryJson{
+		Key:         entry.Key,
+		Title:       entry.Title,
+		Author:      entry.Author,
+		Placement:   entry.Ranking.Placement,
+		SharedPlace: entry.Ranking.SharedPlace,
+		Path:        entry.Path,
+		ApiPath:     api_path(site, year.Key, section.Key, entry.Key),
+		Thumbnails: ThumbnailsJson{
+			Default: assets.EncodeImageJson(entry.Thumbnails.Default),
+			Sources: assets.EncodeImagesJson(entry.Thumbnails.Sources),
+		},
+	}
+	if entry.Ranking.HasPoints {
+		points := entry.Ranking.Points
+		result.Points = &points
+	}
+	return result
+}
+
+func encode_credits(credits []base.Credit) []CreditJson {
+	result := []CreditJson{}
+	for _, credit := range credits {
+		credit_json := CreditJson{
+			Name:    credit.Name,
+			Key:     authors.Key(credit.Name),
+			Aliases: credit.Aliases,
+			Roles:   credit.Roles,
+		}
+		if len(credit.Members) > 0 {
+			credit_json.Members = encode_credits(credit.Members)
+		}
+		result = append(result, credit_json)
+	}
+	return result
+}
+
+func encode_asset(asset base.Asset) AssetJson {
+	result := AssetJson{
+		Type:  asset.Type,
+		Title: asset.Title,
+	}
+	asset_type, ok := assets.Get(asset.Type)
+	if !ok {
+		return result
+	}
+	if encoder, ok := asset_type.(assets.JsonEncoder); ok {
+		result.Data = encoder.EncodeJson(asset.Data)
+	}
+	return result
+}
+
+func find_year(site ApiSite, key string) *base.Year {
+	for _, year := range site.Years {
+		if year.Key == key {
+			return year
+		}
+	}
+	return nil
+}
+
+func find_section(year *base.Year, key string) *base.Section {
+	for _, section := range year.Sections {
+		if section.Key == key {
+			return section
+		}
+	}
+	return nil
+}
+
+func find_entry(section *base.Section, key string) *base.Entry {
+	for _, entry := range section.Entries {
+		if entry.Key == key {
+			return entry
+		}
+	}
+	return nil
+}
+
+func write_error(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	data, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{message})
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+// Writes a JSON document with cache headers. Documents are validated
+// with an entity tag calculated from the document contents, so
+// clients can cheaply check if anything has changed.
+func write_document(
+	w http.ResponseWriter,
+	r *http.Request,
+	cache_time int,
+	document interface{}) {
+	data, err_json := json.Marshal(document)
+	if err_json != nil {
+		write_error(w, http.StatusInternalServerError, "Internal server error!")
+		log.Printf("Unable to encode %s: %s", r.URL.Path, err_json)
+		return
+	}
+	data = append(data, '\n')
+	checksum := sha256.Sum256(data)
+	etag := fmt.Sprintf(
+		"\"%s\"", base64.RawURLEncoding.EncodeToString(checksum[:12]))
+	header := w.Header()
+	header.Set("Content-Type", "application/json")
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", cache_time))
+	header.Set("ETag", etag)
+	header.Set("Access-Control-Allow-Origin", "*")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(data)
+}
+
+type RequestHandlerFunc func(
+	site ApiSite,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request)
+
+func handle_years(
+	site ApiSite,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request) {
+	document := YearsJson{
+		Version: API_VERSION,
+		Years:   []YearSummaryJson{},
+	}
+	for _, year := range site.Years {
+		document.Years = append(
+			document.Years, encode_year_summary(site, year))
+	}
+	write_document(w, r, CACHE_TIME_YEARS_S, document)
+}
+
+func handle_year(
+	site ApiSite,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request) {
+	year := find_year(site, path_elements["Year"])
+	if year == nil {
+		write_error(w, http.StatusNotFound, "Year not found")
+		return
+	}
+	document := YearJson{
+		Version:         API_VERSION,
+		YearSummaryJson: encode_year_summary(site, year),
+		Sections:        []SectionSummaryJson{},
+	}
+	for _, section := range year.Sections {
+		document.Sections = append(
+			document.Sections, encode_section_summary(site, year, section))
+	}
+	write_document(w, r, CACHE_TIME_DOCUMENT_S, document)
+}
+
+// Sections are paginated with the same offset parameter and page size
+// as the section pages of the site.
+func handle_section(
+	site ApiSite,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request) {
+	year := find_year(site, path_elements["Year"])
+	if year == nil {
+		write_error(w, http.StatusNotFound, "Year not found")
+		return
+	}
+	section := find_section(year, path_elements["Section"])
+	if section == nil {
+		write_error(w, http.StatusNotFound, "Section not found")
+		return
+	}
+	offset := 0
+	if offset_str := r.FormValue("offset"); offset_str != "" {
+		value, err_offset := strconv.Atoi(offset_str)
+		if err_offset != nil || value < 0 {
+			write_error(
+				w,
+				http.StatusBadRequest,
+				fmt.Sprintf("Invalid offset %s", offset_str))
+			return
+		}
+		offset = value
+	}
+	total := len(section.Entries)
+	end := offset + MAX_SECTION_ENTRIES
+	if end > total {
+		end = total
+	}
+	section_path := api_path(site, year.Key, section.Key)
+	pagination := PaginationJson{
+		Offset: offset,
+		Limit:  MAX_SECTION_ENTRIES,
+		Total:  total,
+	}
+	if offset > 0 {
+		prev_offset := offset - MAX_SECTION_ENTRIES
+		if prev_offset < 0 {
+			prev_offset = 0
+		}
+		pagination.Prev = fmt.Sprintf("%s?offset=%d", section_path, prev_offset)
+	}
+	if end < total {
+		pagination.Next = fmt.Sprintf("%s?offset=%d", section_path, end)
+	}
+	document := SectionJson{
+		Version:            API_VERSION,
+		Year:               encode_year_summary(site, year),
+		SectionSummaryJson: encode_section_summary(site, year, section),
+		Entries:            []EntrySummaryJson{},
+		Pagination:         pagination,
+	}
+	for index := offset; index < end; index++ {
+		document.Entries = append(
+			document.Entries,
+			encode_entry_summary(site, year, section, section.Entries[index]))
+	}
+	write_document(w, r, CACHE_TIME_DOCUMENT_S, document)
+}
+
+func handle_entry(
+	site ApiSite,
+	path_elements map[string]string,
+	w http.ResponseWriter,
+	r *http.Request) {
+	year := find_year(site, path_elements["Year"])
+	if year == nil {
+		write_error(w, http.StatusNotFound, "Year not found")
+		return
+	}
+	section := find_section(year, path_elements["Section"])
+	if section == nil {
+		write_error(w, http.StatusNotFound, "Section not found")
+		return
+	}
+	entry := find_entry(section, path_elements["Entry"])
+	if entry == nil {
+		write_error(w, http.StatusNotFound, "Entry not found")
+		return
+	}
+	document := EntryJson{
+		Version:          API_VERSION,
+		Year:             encode_year_summary(site, year),
+		Section:          encode_section_summary(site, year, section),
+		EntrySummaryJson: encode_entry_summary(site, year, section, entry),
+		Description:      entry.Description,
+		Credits: CreditsJson{
+			Groups:  encode_credits(entry.Credits.Groups),
+			Persons: encode_credits(entry.Credits.Persons),
+		},
+		Assets:        []AssetJson{},
+		ExternalLinks: entry.ExternalLinks,
+		Files:         []DownloadFileJson{},
+	}
+	if entry.Asset.Type != "" {
+		document.Assets = append(document.Assets, encode_asset(entry.Asset))
+	}
+	for _, asset := range entry.ExtraAssets {
+		document.Assets = append(document.Assets, encode_asset(asset))
+	}
+	if document.ExternalLinks == nil {
+		document.ExternalLinks = []base.ExternalLinksSection{}
+	}
+	for _, file := range entry.Files {
+		document.Files = append(document.Files, DownloadFileJson{
+			Filename: file.Filename,
+			Url:      assets.FileUrl(file.Path, file.Sha256),
+			Size:     file.Size,
+			Sha256:   file.Sha256,
+			Platform: file.Platform,
+		})
+	}
+	write_document(w, r, CACHE_TIME_DOCUMENT_S, document)
+}
+
+type RequestHandler struct {
+	regex    *regexp.Regexp
+	callback RequestHandlerFunc
+}
+
+var HANDLERS = []RequestHandler{
+	{regexp.MustCompile(`^v1/(?P<Year>\d{4})/(?P<Section>[a-z0-9\-]+)/(?P<Entry>[a-z0-9\-]+)/?$`),
+		handle_entry},
+	{regexp.MustCompile(`^v1/(?P<Year>\d{4})/(?P<Section>[a-z0-9\-]+)/?$`), handle_section},
+	{regexp.MustCompile(`^v1/(?P<Year>\d{4})/?$`), handle_year},
+	{regexp.MustCompile(`^v1/?$`), handle_years},
+}
+
+func route_request(site ApiSite, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		write_error(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	path := r.URL.EscapedPath()
+	for _, handler := range HANDLERS {
+		match := handler.regex.FindStringSubmatch(path)
+		if match == nil {
+			continue
+		}
+		path_elements := make(map[string]string)
+		for i, name := range handler.regex.SubexpNames() {
+			path_elements[name] = match[i]
+		}
+		handler.callback(site, path_elements, w, r)
+		return
+	}
+	write_error(w, http.StatusNotFound, "Not found")
+}
+
+// Serves the read-only JSON API. Request paths are expected to be
+// relative to the API root, like "v1/2019/demo".
+func Renderer(
+	settings base.SiteSettings,
+	site_state *state.SiteState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		site := ApiSite{
+			Settings: settings,
+			Years:    site_state.Years(),
+		}
+		route_request(site, w, r)
+	}
+}
@@ -0,0 +1,229 @@
+package state
+
+import (
+	"base"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Whole-archive index that holds all years and sections in a single
+// file, so that the site can be loaded without reading every section
+// cache. Fingerprints of each year are the year meta.json file and
+// the fingerprints of its sections with paths relative to the data
+// directory. Years refer to files by their paths in the data
+// directory, so the index is only valid for the same directory.
+type archive_index struct {
+	Version      int
+	Schema       string
+	SiteRoot     string
+	DataDir      string
+	Years        []*base.Year
+	Fingerprints map[string][]MetaFingerprint
+}
+
+// Returns keys of the directories in the data directory that are read
+// as years.
+func read_year_keys(fs_directory string) ([]string, error) {
+	infos, err_dir := ioutil.ReadDir(fs_directory)
+	if err_dir != nil {
+		return nil, err_dir
+	}
+	var result []string
+	for _, info := range infos {
+		if info.IsDir() && is_year_key(info.Name()) {
+			result = append(result, info.Name())
+		}
+	}
+	return result, nil
+}
+
+// Returns fingerprints of a year meta.json file and of all sections
+// that it lists.
+func year_fingerprints(
+	fs_directory string, key string) ([]MetaFingerprint, error) {
+	year_fingerprint, err_year := read_fingerprint(
+		fs_directory, path.Join(key, "meta.json"))
+	if err_year != nil {
+		return nil, err_year
+	}
+	data, err_meta := ReadMetaBytes(filepath.Join(fs_directory, key))
+	if err_meta != nil {
+		return nil, err_meta
+	}
+	var meta YearMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	result := []MetaFingerprint{year_fingerprint}
+	for _, section_key := range meta.Sections {
+		section_fingerprints, err := SectionFingerprints(
+			filepath.Join(fs_directory, key, section_key))
+		if err != nil {
+			return nil, err
+		}
+		for _, fingerprint := range section_fingerprints {
+			fingerprint.Path = path.Join(key, section_key, fingerprint.Path)
+			result = append(result, fingerprint)
+		}
+	}
+	return result, nil
+}
+
+// Returns the absolute data directory path that is stored in the index.
+func index_data_dir(fs_directory string) string {
+	absolute, err := filepath.Abs(fs_directory)
+	if err != nil {
+		return filepath.Clean(fs_directory)
+	}
+	return absolute
+}
+
+func read_archive_index(
+	fs_directory string,
+	site_root string,
+	index_path string) ([]*base.Year, error) {
+	input, err_input := os.Open(index_path)
+	if err_input != nil {
+		return nil, fmt.Errorf("No archive index exists: %s", index_path)
+	}
+	defer input.Close()
+	var index archive_index
+	if err := gob.NewDecoder(input).Decode(&index); err != nil {
+		return nil, fmt.Errorf(
+			"Error while reading archive index %s: %s", index_path, err)
+	}
+	if index.Version != CACHE_FORMAT_VERSION ||
+		index.Schema != get_cache_schema() {
+		return nil, fmt.Errorf(
+			"Archive index %s is from a different program version", index_path)
+	}
+	if index.SiteRoot != site_root {
+		return nil, fmt.Errorf(
+			"Archive index %s is for site root '%s'",
+			index_path,
+			index.SiteRoot)
+	}
+	if index.DataDir != index_data_dir(fs_directory) {
+		return nil, fmt.Errorf(
+			"Archive index %s is for data directory %s",
+			index_path,
+			index.DataDir)
+	}
+
+	// New years and removed years are noticed from the year
+	// directories and changed years from their fingerprints.
+	year_keys, err_keys := read_year_keys(fs_directory)
+	if err_keys != nil {
+		return nil, err_keys
+	}
+	if len(year_keys) != len(index.Fingerprints) {
+		return nil, fmt.Errorf(
+			"Archive index %s does not have the same years as %s",
+			index_path,
+			fs_directory)
+	}
+	refreshed := false
+	for _, key := range year_keys {
+		fingerprints, ok := index.Fingerprints[key]
+		if !ok {
+			return nil, fmt.Errorf(
+				"Archive index %s does not have year %s", index_path, key)
+		}
+		year_refreshed, err := CheckFingerprints(fs_directory, fingerprints)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Archive index %s is out of date: %v", index_path, err)
+		}
+		refreshed = refreshed || year_refreshed
+	}
+	if refreshed {
+		if err := write_archive_index(index_path, index); err != nil {
+			log.Printf("Failed to refresh archive index %s: %v", index_path, err)
+		}
+	}
+	return index.Years, nil
+}
+
+func write_archive_index(index_path string, index archive_index) error {
+	tmpfile, err_tmpfile := ioutil.TempFile(
+		filepath.Dir(index_path), ".archive-index")
+	if err_tmpfile != nil {
+		return err_tmpfile
+	}
+	defer os.Remove(tmpfile.Name())
+	err_encode := gob.NewEncoder(tmpfile).Encode(index)
+	if err := tmpfile.Close(); err != nil && err_encode == nil {
+		err_encode = err
+	}
+	if err_encode != nil {
+		return err_encode
+	}
+	return os.Rename(tmpfile.Name(), index_path)
+}
+
+// Creates the site state like New() but reads all years from a single
+// archive index file when none of the metadata files that it was
+// created from have changed. Otherwise years are read from the
+// section caches and metadata files and a new index is written.
+// Index is not written when some years or sections fail to load, so
+// that they are tried again on the next start. Empty index path
+// disables the index.
+func NewIndexed(
+	fs_directory string,
+	site_root string,
+	index_path string) (*SiteState, error) {
+	if index_path == "" {
+		return New(fs_directory, site_root)
+	}
+	register_gob_interfaces()
+	years, err_index := read_archive_index(fs_directory, site_root, index_path)
+	if err_index == nil {
+		state := SiteState{
+			SiteRoot: site_root,
+			DataDir:  fs_directory,
+			years:    years,
+		}
+		return &state, nil
+	}
+	log.Println(err_index)
+
+	// Fingerprints are taken before reading the years, so that files
+	// changing during the read make the index out of date instead of
+	// wrong.
+	fingerprints := make(map[string][]MetaFingerprint)
+	year_keys, err_fingerprints := read_year_keys(fs_directory)
+	for _, key := range year_keys {
+		if err_fingerprints != nil {
+			break
+		}
+		fingerprints[key], err_fingerprints = year_fingerprints(
+			fs_directory, key)
+	}
+
+	state, err_state := New(fs_directory, site_root)
+	if err_state != nil {
+		return nil, err_state
+	}
+	if err_fingerprints != nil || len(state.LoadErrors()) > 0 {
+		log.Printf("Not writing archive index %s", index_path)
+		return state, nil
+	}
+	index := archive_index{
+		Version:      CACHE_FORMAT_VERSION,
+		Schema:       get_cache_schema(),
+		SiteRoot:     site_root,
+		DataDir:      index_data_dir(fs_directory),
+		Years:        state.Years(),
+		Fingerprints: fingerprints,
+	}
+	if err := write_archive_index(index_path, index); err != nil {
+		log.Println(err)
+	}
+	return state, nil
+}
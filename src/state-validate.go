@@ -0,0 +1,343 @@
+package state
+
+import (
+	"assets"
+	"base"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of problems that validation reports.
+var PROBLEM_INVALID_KEY = "invalid-key"
+var PROBLEM_INVALID_METADATA = "invalid-metadata"
+var PROBLEM_OVERSIZE_METADATA = "oversize-metadata"
+var PROBLEM_MISSING_FILE = "missing-file"
+var PROBLEM_UNKNOWN_ASSET_TYPE = "unknown-asset-type"
+var PROBLEM_CHECKSUM_MISMATCH = "checksum-mismatch"
+var PROBLEM_FILE_MISMATCH = "file-mismatch"
+var PROBLEM_ORPHAN_DIRECTORY = "orphan-directory"
+
+// Kind of warnings that validation reports. Warnings do not make
+// the data directory invalid.
+var WARNING_RANKING = "ranking"
+
+// Problem with a file or a directory in the data directory. Problems
+// are also errors, so readers can return them as they are and
+// validation can tell their kinds apart.
+type Problem struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (problem Problem) Error() string {
+	return fmt.Sprintf("%s: %s", problem.Path, problem.Message)
+}
+
+// Validation result of a data directory.
+type Report struct {
+	Directory string    `json:"directory"`
+	Years     int       `json:"years"`
+	Sections  int       `json:"sections"`
+	Entries   int       `json:"entries"`
+	Problems  []Problem `json:"problems"`
+	Warnings  []Problem `json:"warnings"`
+}
+
+func (report *Report) add(
+	kind string, path string, format string, args ...interface{}) {
+	report.Problems = append(
+		report.Problems, Problem{kind, path, fmt.Sprintf(format, args...)})
+}
+
+// Errors that are not problems are reported as invalid metadata of the
+// given path.
+func (report *Report) add_error(path string, err error) {
+	if problem, ok := err.(Problem); ok {
+		report.Problems = append(report.Problems, problem)
+		return
+	}
+	report.add(PROBLEM_INVALID_METADATA, path, "%v", err)
+}
+
+func (report *Report) check_key(directory string, key string) bool {
+	if VALID_KEY.MatchString(key) {
+		return true
+	}
+	report.add(PROBLEM_INVALID_KEY, directory, "Key '%s' is not a valid one", key)
+	return false
+}
+
+// Reads and decodes the meta.json file of a directory. Returns false
+// when the file can not be used and the problem has been reported.
+func (report *Report) read_meta(directory string, value interface{}) bool {
+	meta_path := filepath.Join(directory, "meta.json")
+	stats, err_stat := os.Stat(meta_path)
+	if os.IsNotExist(err_stat) {
+		report.add(PROBLEM_MISSING_FILE, meta_path, "Metadata file does not exist")
+		return false
+	}
+	if err_stat == nil && stats.Size() > MAX_METADATA_SIZE {
+		report.add(
+			PROBLEM_OVERSIZE_METADATA,
+			meta_path,
+			"Size of %d bytes exceeds the maximum of %d bytes",
+			stats.Size(),
+			MAX_METADATA_SIZE)
+		return false
+	}
+	data, err_meta := ReadMetaBytes(directory)
+	if err_meta != nil {
+		report.add_error(meta_path, err_meta)
+		return false
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		report.add(PROBLEM_INVALID_METADATA, meta_path, "%v", err)
+		return false
+	}
+	return true
+}
+
+// Reports subdirectories that the metadata does not list. Hidden
+// directories, like unfinished imports, are skipped.
+func (report *Report) check_orphans(directory string, listed []string) {
+	infos, err_dir := ioutil.ReadDir(directory)
+	if err_dir != nil {
+		report.add_error(directory, err_dir)
+		return
+	}
+	known := make(map[string]bool)
+	for _, key := range listed {
+		known[key] = true
+	}
+	for _, info := range infos {
+		if !info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if !known[info.Name()] {
+			report.add(
+				PROBLEM_ORPHAN_DIRECTORY,
+				filepath.Join(directory, info.Name()),
+				"Directory is not listed in %s",
+				filepath.Join(directory, "meta.json"))
+		}
+	}
+}
+
+// Reports asset types that are not registered. Entries with unknown
+// asset types can not be decoded any further.
+func (report *Report) check_asset_types(
+	meta_path string, fields MetaFields) bool {
+	type asset_type_meta struct {
+		Type string
+	}
+	// Other decoding errors are reported when the whole entry is
+	// decoded.
+	var metas []asset_type_meta
+	if data, ok := fields["assets"]; ok {
+		json.Unmarshal(data, &metas)
+	}
+	if data, ok := fields["asset"]; ok {
+		var meta asset_type_meta
+		json.Unmarshal(data, &meta)
+		metas = append(metas, meta)
+	}
+	known := true
+	for _, meta := range metas {
+		if _, ok := assets.Get(meta.Type); !ok {
+			report.add(
+				PROBLEM_UNKNOWN_ASSET_TYPE,
+				meta_path,
+				"Unknown asset type '%s'",
+				meta.Type)
+			known = false
+		}
+	}
+	return known
+}
+
+// Checks that an asset file exists and matches its checksum, which is
+// created like image checksums are.
+func verify_asset_file(fs_path string, expected string) error {
+	if _, err := os.Stat(fs_path); os.IsNotExist(err) {
+		return Problem{PROBLEM_MISSING_FILE, fs_path, "File does not exist"}
+	}
+	checksum, err_checksum := base.CreateFileChecksum(fs_path)
+	if err_checksum != nil {
+		return err_checksum
+	}
+	if checksum != expected {
+		return Problem{
+			PROBLEM_CHECKSUM_MISMATCH,
+			fs_path,
+			fmt.Sprintf("Checksum is %s, expected %s", checksum, expected),
+		}
+	}
+	return nil
+}
+
+// Returns the entry with its ranking when its metadata can be decoded,
+// so that rankings of the section can be checked.
+func (report *Report) validate_entry(entry_dir string, key string) *base.Entry {
+	report.Entries++
+	meta_path := filepath.Join(entry_dir, "meta.json")
+	var fields MetaFields
+	if !report.read_meta(entry_dir, &fields) {
+		return nil
+	}
+	if !report.check_asset_types(meta_path, fields) {
+		return nil
+	}
+	var meta EntryMeta
+	if !report.read_meta(entry_dir, &meta) {
+		return nil
+	}
+	entry := &base.Entry{
+		Key: key,
+		Ranking: base.Ranking{
+			Placement:   meta.Placement,
+			SharedPlace: meta.SharedPlace,
+		},
+	}
+
+	// Every file is checked instead of stopping at the first problem
+	// like ReadEntry() does.
+	start := len(report.Problems)
+	update := func(info *assets.ImageInfoMeta) error {
+		if err := update_image_info(entry_dir, info, true); err != nil {
+			report.add_error(meta_path, err)
+		}
+		return nil
+	}
+	asset_metas := append([]EntryAsset{}, meta.Assets...)
+	if meta.Asset.Type != "" {
+		asset_metas = append(asset_metas, meta.Asset)
+	}
+	verify := func(filename string, checksum string) error {
+		err := verify_asset_file(filepath.Join(entry_dir, filename), checksum)
+		if err != nil {
+			report.add_error(meta_path, err)
+		}
+		return nil
+	}
+	for index := range asset_metas {
+		previous := len(report.Problems)
+		err := update_asset_images(&asset_metas[index], update)
+		// Images with problems do not pass validation either.
+		if err != nil && len(report.Problems) == previous {
+			report.add_error(meta_path, err)
+		}
+		if err != nil {
+			continue
+		}
+		asset_type, _ := assets.Get(asset_metas[index].Type)
+		if verifier, ok := asset_type.(assets.FileVerifier); ok {
+			verifier.VerifyFiles(asset_metas[index].Data, verify)
+		}
+	}
+	update(&meta.Thumbnails.Default)
+	for index := range meta.Thumbnails.Sources {
+		update(&meta.Thumbnails.Sources[index])
+	}
+	for _, file := range meta.Files {
+		if err := validate_download_file_meta(file); err != nil {
+			report.add_error(meta_path, err)
+			continue
+		}
+		err := verify_download_file(base.DownloadFile{
+			FsPath: filepath.Join(entry_dir, file.Filename),
+			Size:   file.Size,
+			Sha256: file.Sha256,
+		})
+		if err != nil {
+			report.add_error(meta_path, err)
+		}
+	}
+
+	// Rest of the entry is checked by reading it like it is read
+	// when the site is loaded.
+	if len(report.Problems) > start {
+		return entry
+	}
+	if _, err := ReadEntry(entry_dir, "", "", key); err != nil {
+		report.add(PROBLEM_INVALID_METADATA, meta_path, "%v", err)
+	}
+	return entry
+}
+
+func (report *Report) validate_section(section_dir string) {
+	report.Sections++
+	var meta SectionMeta
+	if !report.read_meta(section_dir, &meta) {
+		return
+	}
+	if meta.Family != "" && !VALID_KEY.MatchString(meta.Family) {
+		report.add(
+			PROBLEM_INVALID_KEY,
+			filepath.Join(section_dir, "meta.json"),
+			"Competition family '%s' is not a valid key",
+			meta.Family)
+	}
+	section := base.Section{IsRanked: meta.IsRanked, IsOngoing: meta.IsOngoing}
+	for _, key := range meta.Entries {
+		entry_dir := filepath.Join(section_dir, key)
+		if !report.check_key(entry_dir, key) {
+			continue
+		}
+		if entry := report.validate_entry(entry_dir, key); entry != nil {
+			section.Entries = append(section.Entries, entry)
+		}
+	}
+	report.check_orphans(section_dir, meta.Entries)
+	for _, warning := range RankingWarnings(&section) {
+		report.Warnings = append(report.Warnings, Problem{
+			WARNING_RANKING, filepath.Join(section_dir, "meta.json"), warning})
+	}
+}
+
+func (report *Report) validate_year(year_dir string) {
+	report.Years++
+	var meta YearMeta
+	if !report.read_meta(year_dir, &meta) {
+		return
+	}
+	for _, key := range meta.Sections {
+		section_dir := filepath.Join(year_dir, key)
+		if report.check_key(section_dir, key) {
+			report.validate_section(section_dir)
+		}
+	}
+	report.check_orphans(year_dir, meta.Sections)
+}
+
+// Validates all years, sections, and entries of a data directory.
+// Unlike loading the site, validation goes through everything and
+// collects all problems instead of stopping at the first one.
+// Aggregate metadata caches are neither read nor written.
+func Validate(fs_directory string) Report {
+	report := Report{
+		Directory: fs_directory,
+		Problems:  []Problem{},
+		Warnings:  []Problem{},
+	}
+	infos, err_dir := ioutil.ReadDir(fs_directory)
+	if err_dir != nil {
+		report.add_error(fs_directory, err_dir)
+		return report
+	}
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		// Other directories are not loaded as years either.
+		if !is_year_key(info.Name()) {
+			continue
+		}
+		report.validate_year(filepath.Join(fs_directory, info.Name()))
+	}
+	return report
+}
@@ -1,18 +1,27 @@
 package state
 
 import (
+	"assets"
 	"base"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"images"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"search"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 // 256 kilobytes is able to hold 4000 entries with 50 bytes/entry +
@@ -26,31 +35,328 @@ type FileInfo struct {
 
 // Global state of this site. API package updates this and site
 // package uses it to render the pages.
+//
+// Years are published as immutable snapshots. Readers get one
+// consistent version of the archive with Years() and updates replace
+// whole years or sections instead of modifying the already published
+// structures in place.
 type SiteState struct {
-	SiteRoot string
-	DataDir  string
-	Years    []*base.Year
+	SiteRoot    string
+	DataDir     string
+	lock        sync.RWMutex
+	years       []*base.Year
+	load_errors []LoadError
+	listeners   []func(years []*base.Year)
+	// Listeners are notified outside of the state lock, one snapshot
+	// at a time, and only of snapshots newer than the previous one.
+	notify_lock sync.Mutex
+	version     int
+	notified    int
 }
 
-type YoutubeAsset struct {
-	Id string
+// Year or section that failed to load and is left out of the site
+// until it is replaced with data that loads. Section is empty when
+// the whole year failed.
+type LoadError struct {
+	Year    string
+	Section string
+	Path    string
+	Message string
+	// Section keys of the year in their metadata order, so that the
+	// section can be added back to its place.
+	sections []string
 }
 
-type ImageAsset struct {
-	Default base.ImageInfo
-	Sources []base.ImageInfo
+// Returns years and sections that failed to load.
+func (s *SiteState) LoadErrors() []LoadError {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.load_errors
 }
 
-type VimeoAsset struct {
-	Id string
+// Replaces all load errors with the given ones. This is used together
+// with ReplaceYears() when the whole site state is reloaded.
+func (s *SiteState) ReplaceLoadErrors(load_errors []LoadError) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.load_errors = load_errors
 }
 
+// Removes load errors of a year, or of a section when the section key
+// is given. Caller must hold the write lock.
+func (s *SiteState) clear_load_errors(year string, section string) {
+	var remaining []LoadError
+	for _, load_error := range s.load_errors {
+		if load_error.Year == year &&
+			(section == "" || load_error.Section == section) {
+			continue
+		}
+		remaining = append(remaining, load_error)
+	}
+	s.load_errors = remaining
+}
+
+// Returns the quarantined section with the given key. Caller must hold
+// a lock.
+func (s *SiteState) quarantined_section(
+	year string, section string) (LoadError, bool) {
+	for _, load_error := range s.load_errors {
+		if load_error.Year == year && load_error.Section == section {
+			return load_error, true
+		}
+	}
+	return LoadError{}, false
+}
+
+// Returns the current snapshot of years sorted in the reverse
+// order. The returned data is shared between all readers and must not
+// be modified.
+func (s *SiteState) Years() []*base.Year {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.years
+}
+
+// Returns the year with the given key from the current snapshot or
+// nil if no such year exists.
+func (s *SiteState) GetYear(key string) *base.Year {
+	for _, year := range s.Years() {
+		if year.Key == key {
+			return year
+		}
+	}
+	return nil
+}
+
+// Returns true when the year is loaded and its metadata lists the
+// section, either as a loaded or as a quarantined section.
+func (s *SiteState) HasSection(year string, section string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, loaded_year := range s.years {
+		if loaded_year.Key != year {
+			continue
+		}
+		for _, loaded_section := range loaded_year.Sections {
+			if loaded_section.Key == section {
+				return true
+			}
+		}
+	}
+	_, quarantined := s.quarantined_section(year, section)
+	return quarantined
+}
+
+// Registers a function that is called with the new snapshot of years
+// every time years or sections are replaced. The function is also
+// called immediately with the current snapshot. Listeners are called
+// after the state is unlocked, so readers are not blocked by them, but
+// they must not replace years or sections themselves.
+func (s *SiteState) Subscribe(listener func(years []*base.Year)) {
+	s.notify_lock.Lock()
+	defer s.notify_lock.Unlock()
+	s.lock.Lock()
+	s.listeners = append(s.listeners, listener)
+	years := s.years
+	s.lock.Unlock()
+	listener(years)
+}
+
+// Publishes a new snapshot of years. Caller must hold the write lock
+// and call notify() after releasing it.
+func (s *SiteState) publish(years []*base.Year) {
+	s.years = years
+	s.version++
+}
+
+// Calls listeners with the latest snapshot unless they have already
+// been called with it. Caller must not hold the state lock.
+func (s *SiteState) notify() {
+	s.notify_lock.Lock()
+	defer s.notify_lock.Unlock()
+	s.lock.RLock()
+	years := s.years
+	version := s.version
+	listeners := s.listeners
+	s.lock.RUnlock()
+	if version == s.notified {
+		return
+	}
+	s.notified = version
+	for _, listener := range listeners {
+		listener(years)
+	}
+}
+
+// Replaces all years with the given ones. This is used when the
+// whole site state is reloaded.
+func (s *SiteState) ReplaceYears(years []*base.Year) {
+	defer s.notify()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.publish(years)
+}
+
+// Adds a new year or replaces an existing year with the same number.
+func (s *SiteState) ReplaceYear(year *base.Year) {
+	s.replace_year(year, nil)
+}
+
+// Replaces a year together with the load errors of its quarantined
+// sections.
+func (s *SiteState) replace_year(year *base.Year, load_errors []LoadError) {
+	defer s.notify()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	years := make([]*base.Year, 0, len(s.years)+1)
+	year_added := false
+	for _, old_year := range s.years {
+		if !year_added && old_year.Year <= year.Year {
+			years = append(years, year)
+			year_added = true
+		}
+		if old_year.Year == year.Year {
+			continue
+		}
+		years = append(years, old_year)
+	}
+	if !year_added {
+		years = append(years, year)
+	}
+	s.clear_load_errors(year.Key, "")
+	s.load_errors = append(s.load_errors, load_errors...)
+	s.publish(years)
+}
+
+// Replaces load errors of a year that failed to load as a whole. The
+// previously loaded version of the year is kept.
+func (s *SiteState) replace_year_load_errors(
+	year string, load_errors []LoadError) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clear_load_errors(year, "")
+	s.load_errors = append(s.load_errors, load_errors...)
+}
+
+// Adds a quarantined section back to its place in the metadata order
+// of the year sections.
+func restore_section(
+	sections []*base.Section,
+	section *base.Section,
+	order []string) []*base.Section {
+	by_key := make(map[string]*base.Section)
+	for _, old_section := range sections {
+		by_key[old_section.Key] = old_section
+	}
+	by_key[section.Key] = section
+	var result []*base.Section
+	for _, key := range order {
+		if ordered, ok := by_key[key]; ok {
+			result = append(result, ordered)
+			delete(by_key, key)
+		}
+	}
+	for _, old_section := range sections {
+		if _, ok := by_key[old_section.Key]; ok {
+			result = append(result, old_section)
+		}
+	}
+	return result
+}
+
+// Replaces an existing section of an existing year. Sections that
+// were quarantined when the year was loaded can be replaced as well.
+// The year structure is copied so that readers of the previous
+// snapshot keep seeing the old section list.
+func (s *SiteState) ReplaceSection(year_key string, section *base.Section) error {
+	defer s.notify()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for year_index, old_year := range s.years {
+		if old_year.Key != year_key {
+			continue
+		}
+		new_year := *old_year
+		new_year.Sections = make([]*base.Section, len(old_year.Sections))
+		copy(new_year.Sections, old_year.Sections)
+		replaced := false
+		for section_index, old_section := range old_year.Sections {
+			if old_section.Key == section.Key {
+				new_year.Sections[section_index] = section
+				replaced = true
+			}
+		}
+		if !replaced {
+			quarantined, ok := s.quarantined_section(year_key, section.Key)
+			if !ok {
+				return fmt.Errorf(
+					"Section %s does not exist in year %s",
+					section.Key,
+					year_key)
+			}
+			new_year.Sections = restore_section(
+				new_year.Sections, section, quarantined.sections)
+		}
+		years := make([]*base.Year, len(s.years))
+		copy(years, s.years)
+		years[year_index] = &new_year
+		s.clear_load_errors(year_key, section.Key)
+		s.publish(years)
+		return nil
+	}
+	return fmt.Errorf("Year %s does not exist", year_key)
+}
+
+// Re-reads a year from the data directory and replaces it in the
+// current state. Sections that fail to load are quarantined like when
+// the site is loaded. Year that fails to load as a whole keeps its
+// previous version and is returned as an error. Aggregate metadata
+// caches of the sections are used when their metadata has not changed.
 func (s *SiteState) UpdateYear(year string) error {
+	matched_year, _ := regexp.MatchString("^[0-9]{4}$", year)
+	if !matched_year {
+		return fmt.Errorf("Year %s is not a valid year key", year)
+	}
+	year_data, load_errors := read_site_year_degraded(
+		s.DataDir, s.SiteRoot, year)
+	for _, load_error := range load_errors {
+		log.Printf("Quarantined %s: %s", load_error.Path, load_error.Message)
+	}
+	if year_data == nil && len(load_errors) > 0 {
+		s.replace_year_load_errors(year, load_errors)
+		return fmt.Errorf("%s: %s", load_errors[0].Path, load_errors[0].Message)
+	}
+	if year_data == nil {
+		return fmt.Errorf("Year %s is out of range", year)
+	}
+	s.replace_year(year_data, load_errors)
 	return nil
 }
 
+// Re-reads a section of an already loaded year from the data
+// directory and replaces it in the current state. Aggregate metadata
+// cache of the section is rebuilt in the process.
 func (s *SiteState) UpdateSection(year string, section string) error {
-	return nil
+	if s.GetYear(year) == nil {
+		return fmt.Errorf("Year %s is not loaded", year)
+	}
+	if !VALID_KEY.MatchString(section) {
+		return fmt.Errorf("Section for year %s has invalid key %s", year, section)
+	}
+	section_dir := filepath.Join(s.DataDir, year, section)
+	cache := filepath.Join(section_dir, "meta.aggregate.gob")
+	if err := os.Remove(cache); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	section_data, err_section := ReadSection(
+		section_dir,
+		fmt.Sprintf("%s/_data/%s/%s", s.SiteRoot, year, section),
+		fmt.Sprintf("%s/%s/%s", s.SiteRoot, year, section),
+		section)
+	if err_section != nil {
+		return err_section
+	}
+	return s.ReplaceSection(year, section_data)
 }
 
 func ReadMetaBytes(directory string) ([]byte, error) {
@@ -73,111 +379,399 @@ func ReadMetaBytes(directory string) ([]byte, error) {
 	}
 }
 
-type ImageInfoMeta struct {
-	Filename string
-	Type     string
-	Checksum string
-	Size     base.Resolution
+// Metadata file fields for tools that update only some of them and
+// keep the rest as they are.
+type MetaFields map[string]json.RawMessage
+
+// Reads fields of a meta.json file in the given directory. Missing
+// file results in empty fields.
+func ReadMetaFields(directory string) (MetaFields, error) {
+	fields := make(MetaFields)
+	data, err_meta := ReadMetaBytes(directory)
+	if os.IsNotExist(err_meta) {
+		return fields, nil
+	}
+	if err_meta != nil {
+		return nil, err_meta
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("%s: %v", directory, err)
+	}
+	return fields, nil
+}
+
+func (fields MetaFields) Set(key string, value interface{}) {
+	data, _ := json.Marshal(value)
+	fields[key] = data
+}
+
+func WriteMetaFields(directory string, fields MetaFields) error {
+	data, err_marshal := json.MarshalIndent(fields, "", "  ")
+	if err_marshal != nil {
+		return err_marshal
+	}
+	target := filepath.Join(directory, "meta.json")
+	return ioutil.WriteFile(target, append(data, '\n'), 0644)
+}
+
+// Creates a key from a title that is valid for ReadEntry and is not
+// in the used keys.
+func EntryKey(title string, used map[string]bool) string {
+	key := search.Slug(title)
+	if key == "" {
+		key = "entry"
+	}
+	if !VALID_KEY.MatchString(key) {
+		// Keys need to start with a letter and their first part needs
+		// to have more than one character, like in "a-team".
+		key = "entry-" + key
+	}
+	result := key
+	for suffix := 2; used[result]; suffix++ {
+		result = fmt.Sprintf("%s-%d", key, suffix)
+	}
+	return result
 }
 
 type ThumbnailsMeta struct {
-	Default ImageInfoMeta
-	Sources []ImageInfoMeta
+	Default assets.ImageInfoMeta   `json:"default"`
+	Sources []assets.ImageInfoMeta `json:"sources,omitempty"`
 }
 
 type EntryAsset struct {
-	Type string
-	Data interface{}
+	Type  string
+	Title string
+	Data  interface{}
 }
 
-func validate_image_info_meta(info ImageInfoMeta) error {
-	if len(info.Filename) < len("a.png") {
-		return fmt.Errorf(
-			"Image file name '%s' is too short to be a valid one",
-			info.Filename)
+func (asset *EntryAsset) UnmarshalJSON(data []byte) error {
+	type AssetMeta struct {
+		Type  string
+		Title string
+		Data  json.RawMessage
 	}
-	if len(info.Type) < len("image/png") {
+	var asset_meta AssetMeta
+	err_meta := json.Unmarshal(data, &asset_meta)
+	if err_meta != nil {
+		return err_meta
+	}
+	asset_type, ok := assets.Get(asset_meta.Type)
+	if !ok {
+		return fmt.Errorf("Unknown asset type %s", asset_meta.Type)
+	}
+	// Asset data is validated after its image information has been
+	// read from the entry directory.
+	asset_data, err_data := asset_type.Decode(asset_meta.Data)
+	if err_data != nil {
+		return err_data
+	}
+	asset.Type = asset_meta.Type
+	asset.Title = asset_meta.Title
+	asset.Data = asset_data
+	return nil
+}
+
+type DownloadFileMeta struct {
+	Filename string
+	Size     int64
+	Sha256   string
+	Platform string
+}
+
+type EntryMeta struct {
+	Title         string
+	Author        string `json:""`
+	Credits       base.Credits
+	Placement     int
+	Points        *float64
+	SharedPlace   bool `json:"shared-place"`
+	Asset         EntryAsset
+	Assets        []EntryAsset
+	Description   string                      `json:""`
+	ExternalLinks []base.ExternalLinksSection `json:"external-links"`
+	Files         []DownloadFileMeta
+	Thumbnails    ThumbnailsMeta
+}
+
+var SHA256_HEX = regexp.MustCompile("^[0-9a-f]{64}$")
+
+func validate_download_file_meta(info DownloadFileMeta) error {
+	if info.Filename == "" ||
+		strings.HasPrefix(info.Filename, "/") ||
+		strings.Contains(info.Filename, "..") {
 		return fmt.Errorf(
-			"Image %s type '%s' is too short to be a valid one",
-			info.Filename,
-			info.Type)
+			"Download file name '%s' is not a valid one", info.Filename)
 	}
-	if len(info.Checksum) < 6 {
+	if info.Size <= 0 {
 		return fmt.Errorf(
-			"Image %s checksum '%s' does not contain enough entropy",
+			"Download %s size %d is not a valid one",
 			info.Filename,
-			info.Checksum)
+			info.Size)
 	}
-	if info.Size.X < 16 || info.Size.Y < 16 {
+	if !SHA256_HEX.MatchString(info.Sha256) {
 		return fmt.Errorf(
-			"Image %s size %dx%d is too small!",
+			"Download %s SHA-256 checksum '%s' is not a valid one",
 			info.Filename,
-			info.Size.X,
-			info.Size.Y)
+			info.Sha256)
 	}
 	return nil
 }
 
-func (asset *EntryAsset) UnmarshalJSON(data []byte) error {
-	type AssetType struct {
-		Type string
+func validate_credit(credit base.Credit, can_have_members bool) error {
+	if strings.TrimSpace(credit.Name) == "" {
+		return fmt.Errorf("Credit is missing a name")
 	}
-	var asset_type AssetType
-	err_type := json.Unmarshal(data, &asset_type)
-	if err_type != nil {
-		return err_type
+	for _, alias := range credit.Aliases {
+		if strings.TrimSpace(alias) == "" {
+			return fmt.Errorf("Credit %s has an empty alias", credit.Name)
+		}
 	}
-	asset.Type = asset_type.Type
-	if asset_type.Type == "image" {
-		type AssetData struct {
-			Data ThumbnailsMeta
+	if len(credit.Members) > 0 && !can_have_members {
+		return fmt.Errorf(
+			"Credit %s can not have members as it is not a group", credit.Name)
+	}
+	for _, member := range credit.Members {
+		if err := validate_credit(member, false); err != nil {
+			return err
 		}
-		var asset_data AssetData
-		err_data := json.Unmarshal(data, &asset_data)
-		if err_data != nil {
-			return err_data
+	}
+	return nil
+}
+
+func validate_credits(credits base.Credits) error {
+	for _, group := range credits.Groups {
+		if err := validate_credit(group, true); err != nil {
+			return err
 		}
-		if err := validate_image_info_meta(asset_data.Data.Default); err != nil {
+	}
+	for _, person := range credits.Persons {
+		if err := validate_credit(person, false); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		image_sources := make([]base.ImageInfo, len(asset_data.Data.Sources))
-		for index, image := range asset_data.Data.Sources {
-			if err := validate_image_info_meta(image); err != nil {
-				return fmt.Errorf(
-					"Source image error %s: %v",
-					asset_data.Data.Default.Filename, err)
+func read_download_files(
+	data_path string,
+	fs_directory string,
+	files []DownloadFileMeta) ([]base.DownloadFile, error) {
+	var result []base.DownloadFile
+	for _, file := range files {
+		if err := validate_download_file_meta(file); err != nil {
+			return nil, err
+		}
+		result = append(result, base.DownloadFile{
+			Filename: path.Base(file.Filename),
+			Path: path.Clean(
+				fmt.Sprintf("%s/%s", data_path, file.Filename)),
+			FsPath:   filepath.Join(fs_directory, file.Filename),
+			Size:     file.Size,
+			Sha256:   file.Sha256,
+			Platform: file.Platform,
+		})
+	}
+	return result, nil
+}
+
+// Verifies that a downloadable file exists and has the size and the
+// SHA-256 checksum that its metadata claims.
+func verify_download_file(file base.DownloadFile) error {
+	f, err_open := os.Open(file.FsPath)
+	if os.IsNotExist(err_open) {
+		return Problem{PROBLEM_MISSING_FILE, file.FsPath, "File does not exist"}
+	}
+	if err_open != nil {
+		return err_open
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	size, err_read := io.Copy(hasher, f)
+	if err_read != nil {
+		return err_read
+	}
+	if size != file.Size {
+		return Problem{
+			PROBLEM_FILE_MISMATCH,
+			file.FsPath,
+			fmt.Sprintf(
+				"Size is %d bytes, expected %d bytes", size, file.Size),
+		}
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if checksum != file.Sha256 {
+		return Problem{
+			PROBLEM_CHECKSUM_MISMATCH,
+			file.FsPath,
+			fmt.Sprintf(
+				"SHA-256 checksum is %s, expected %s", checksum, file.Sha256),
+		}
+	}
+	return nil
+}
+
+// Images with complete metadata are read without their files.
+func image_info_complete(info assets.ImageInfoMeta) bool {
+	return info.Type != "" && info.Checksum != "" && info.Size.X > 0
+}
+
+// Reads the type, size, and checksum of an image from its file when
+// they are missing from the metadata and fills them in. Complete
+// metadata is only checked against the file when verify is set, as
+// that reads the whole file. Values that differ from the file are
+// errors. Archives can be copied without their images, so complete
+// metadata of missing files is trusted.
+func update_image_info(
+	fs_directory string, info *assets.ImageInfoMeta, verify bool) error {
+	if info.Filename == "" {
+		return nil
+	}
+	complete := image_info_complete(*info)
+	if complete && !verify {
+		return nil
+	}
+	fs_path := filepath.Join(fs_directory, info.Filename)
+	if _, err := os.Stat(fs_path); os.IsNotExist(err) {
+		if complete {
+			return nil
+		}
+		return Problem{
+			PROBLEM_MISSING_FILE,
+			fs_path,
+			"Image does not exist and its metadata is incomplete",
+		}
+	}
+	if info.Checksum == "" || verify {
+		checksum, err_checksum := base.CreateFileChecksum(fs_path)
+		if err_checksum != nil {
+			return err_checksum
+		}
+		if info.Checksum == "" {
+			info.Checksum = checksum
+		} else if info.Checksum != checksum {
+			return Problem{
+				PROBLEM_CHECKSUM_MISMATCH,
+				fs_path,
+				fmt.Sprintf(
+					"Checksum is %s, expected %s", checksum, info.Checksum),
 			}
-			image_sources[index] = get_entry_image("", "", image)
 		}
-		image_data := ImageAsset{
-			Default: get_entry_image("", "", asset_data.Data.Default),
-			Sources: image_sources,
+	}
+	if info.Type != "" && info.Size.X > 0 && !verify {
+		return nil
+	}
+	size, image_type, err_size := images.ReadSize(fs_path)
+	if err_size != nil {
+		// Headers of other image types, like WebP, can not be read
+		// and their metadata is trusted.
+		if info.Type == "" || images.READABLE_TYPES[info.Type] {
+			return Problem{
+				PROBLEM_FILE_MISMATCH,
+				fs_path,
+				fmt.Sprintf("Image can not be read: %v", err_size),
+			}
 		}
-		asset.Data = image_data
-	} else if asset_type.Type == "youtube" {
-		type AssetData struct {
-			Data YoutubeAsset
+		return nil
+	}
+	if info.Type == "" {
+		info.Type = image_type
+	} else if info.Type != image_type {
+		return Problem{
+			PROBLEM_FILE_MISMATCH,
+			fs_path,
+			fmt.Sprintf("Type is %s, expected %s", image_type, info.Type),
 		}
-		var asset_data AssetData
-		err_data := json.Unmarshal(data, &asset_data)
-		if err_data != nil {
-			return err_data
+	}
+	if info.Size == (base.Resolution{}) {
+		info.Size = size
+	} else if info.Size != size {
+		return Problem{
+			PROBLEM_FILE_MISMATCH,
+			fs_path,
+			fmt.Sprintf(
+				"Size is %dx%d, expected %dx%d",
+				size.X,
+				size.Y,
+				info.Size.X,
+				info.Size.Y),
 		}
-		asset.Data = asset_data.Data
-	} else {
-		return fmt.Errorf("Unknown asset type %s", asset_type)
 	}
 	return nil
 }
 
-type EntryMeta struct {
-	Title         string
-	Author        string `json:""`
-	Asset         EntryAsset
-	Description   string                      `json:""`
-	ExternalLinks []base.ExternalLinksSection `json:"external-links"`
-	Thumbnails    ThumbnailsMeta
+// Updates the images of an asset with the given function before the
+// asset is validated.
+func update_asset_images(
+	asset *EntryAsset,
+	update func(info *assets.ImageInfoMeta) error) error {
+	asset_type, _ := assets.Get(asset.Type)
+	updater, ok := asset_type.(assets.ImageUpdater)
+	if ok {
+		data, err := updater.UpdateImages(asset.Data, update)
+		if err != nil {
+			return err
+		}
+		asset.Data = data
+	}
+	return asset_type.Validate(asset.Data)
+}
+
+// Verifies that an image file matches its located metadata. Remote
+// images do not have a file.
+func verify_image(image base.ImageInfo) error {
+	if image.FsPath == "" {
+		return nil
+	}
+	info := assets.ImageInfoMeta{
+		Filename: filepath.Base(image.FsPath),
+		Type:     image.Type,
+		Checksum: image.Checksum,
+		Size:     image.Size,
+	}
+	return update_image_info(filepath.Dir(image.FsPath), &info, true)
+}
+
+// Returns the images of an entry that are stored in its directory.
+func entry_images(entry *base.Entry) []base.ImageInfo {
+	result := append(
+		[]base.ImageInfo{entry.Thumbnails.Default}, entry.Thumbnails.Sources...)
+	entry_assets := append([]base.Asset{entry.Asset}, entry.ExtraAssets...)
+	for _, asset := range entry_assets {
+		asset_type, _ := assets.Get(asset.Type)
+		if updater, ok := asset_type.(assets.ImageUpdater); ok {
+			result = append(result, updater.Images(asset.Data)...)
+		}
+	}
+	return result
+}
+
+// Verifies that all downloadable files and images of all entries in a
+// section match their metadata. This reads all the files, so it is
+// meant to be done for imported data instead of every time data is
+// loaded.
+func VerifySectionFiles(section *base.Section) error {
+	for _, entry := range section.Entries {
+		for _, file := range entry.Files {
+			if err := verify_download_file(file); err != nil {
+				return fmt.Errorf("%s: %v", entry.Path, err)
+			}
+		}
+		for _, image := range entry_images(entry) {
+			if err := verify_image(image); err != nil {
+				return fmt.Errorf("%s: %v", entry.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func VerifyYearFiles(year *base.Year) error {
+	for _, section := range year.Sections {
+		if err := VerifySectionFiles(section); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type YearMeta struct {
@@ -189,7 +783,21 @@ type SectionMeta struct {
 	Description string
 	IsRanked    bool `json:"is-ranked"`
 	IsOngoing   bool `json:"is-ongoing"`
-	Entries     []string
+	// Sections with different keys, like "demo" and "pc-demo", can
+	// declare that they belong to the same competition family.
+	Family  string
+	Entries []string
+}
+
+// Valid section, entry, and competition family keys.
+var VALID_KEY = regexp.MustCompile("^[a-z]([a-z0-9]+-)*[a-z0-9]+$")
+
+// Returns true for directory names that are read as years. Other
+// names, including numbers outside the valid year range, indicate
+// some random test directories.
+func is_year_key(key string) bool {
+	year, err_conv := strconv.Atoi(key)
+	return err_conv == nil && year >= 1992 && year <= 9999
 }
 
 func ReadYear(
@@ -197,21 +805,35 @@ func ReadYear(
 	data_path string,
 	path_prefix string,
 	key string) (*base.Year, error) {
+	return read_year(
+		fs_directory,
+		data_path,
+		path_prefix,
+		key,
+		func(section string, sections []string, err error) error {
+			return err
+		})
+}
+
+// Reads a year and calls section_failed for each section that fails
+// to load. Reading stops if section_failed returns an error and
+// otherwise the failed section is left out of the year.
+func read_year(
+	fs_directory string,
+	data_path string,
+	path_prefix string,
+	key string,
+	section_failed func(
+		section string, sections []string, err error) error) (*base.Year, error) {
 	_, err_key := regexp.MatchString("^0-9+$", key)
 	if err_key != nil {
 		return nil, fmt.Errorf(
 			"Year %s is not a valid integer key %s", path_prefix, key)
 	}
-	year, err_conv := strconv.Atoi(key)
-	if err_conv != nil {
-		// We are definitely not reading a year directory.
-		return nil, nil
-	}
-	// Check if we are in a valid year range. Some other number
-	// indicates some random test directory.
-	if year < 1992 || year > 9999 {
+	if !is_year_key(key) {
 		return nil, nil
 	}
+	year, _ := strconv.Atoi(key)
 
 	data, err_meta := ReadMetaBytes(fs_directory)
 	if err_meta != nil {
@@ -239,7 +861,11 @@ func ReadYear(
 			section_path_prefix,
 			section_key)
 		if err_section != nil {
-			return nil, err_section
+			err := section_failed(section_key, meta.Sections, err_section)
+			if err != nil {
+				return nil, err
+			}
+			continue
 		}
 		sections = append(sections, section)
 	}
@@ -269,48 +895,269 @@ func read_section_meta(fs_directory string) (*SectionMeta, error) {
 	return &meta, nil
 }
 
+// Format version of aggregate metadata caches. Changes to cached
+// structures are detected from their types, but this needs to be
+// increased when the meaning of cached data changes.
+var CACHE_FORMAT_VERSION = 1
+
+// State of a metadata file when an aggregate cache was written. Path
+// is relative to the section directory.
+type MetaFingerprint struct {
+	Path    string
+	Size    int64
+	ModTime int64
+	Sha256  string
+}
+
+// Aggregate metadata cache of a section with the information that is
+// needed to tell whether it is still valid.
+type section_cache struct {
+	Version      int
+	Schema       string
+	Fingerprints []MetaFingerprint
+	Section      base.Section
+}
+
+// Describes a type and the types that it contains, so that any change
+// in cached structures changes the description.
+func describe_type(
+	value reflect.Type, seen map[reflect.Type]bool, result *strings.Builder) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		result.WriteString(value.Kind().String() + " ")
+		describe_type(value.Elem(), seen, result)
+	case reflect.Array:
+		result.WriteString(fmt.Sprintf("[%d]", value.Len()))
+		describe_type(value.Elem(), seen, result)
+	case reflect.Map:
+		result.WriteString("map[")
+		describe_type(value.Key(), seen, result)
+		result.WriteString("]")
+		describe_type(value.Elem(), seen, result)
+	case reflect.Struct:
+		result.WriteString(value.PkgPath() + "." + value.Name())
+		if seen[value] {
+			return
+		}
+		seen[value] = true
+		result.WriteString("{")
+		for index := 0; index < value.NumField(); index++ {
+			field := value.Field(index)
+			result.WriteString(field.Name + " ")
+			describe_type(field.Type, seen, result)
+			result.WriteString(";")
+		}
+		result.WriteString("}")
+	default:
+		result.WriteString(value.Kind().String())
+	}
+}
+
+var cache_schema string
+var cache_schema_once sync.Once
+
+// Returns a hash of the cached year and section structures and of all
+// asset data types that can be stored in them. New program versions
+// that change these types do not use caches of the old versions.
+func get_cache_schema() string {
+	cache_schema_once.Do(func() {
+		var description strings.Builder
+		seen := make(map[reflect.Type]bool)
+		describe_type(reflect.TypeOf(base.Year{}), seen, &description)
+		for _, gob_type := range assets.GobTypes() {
+			description.WriteString(" ")
+			describe_type(gob_type, seen, &description)
+		}
+		hash := sha256.Sum256([]byte(description.String()))
+		cache_schema = hex.EncodeToString(hash[:8])
+	})
+	return cache_schema
+}
+
+func file_sha256(filename string) (string, error) {
+	input, err_open := os.Open(filename)
+	if err_open != nil {
+		return "", err_open
+	}
+	defer input.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, input); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func read_fingerprint(
+	fs_directory string, relative_path string) (MetaFingerprint, error) {
+	filename := filepath.Join(fs_directory, filepath.FromSlash(relative_path))
+	stats, err_stat := os.Stat(filename)
+	if err_stat != nil {
+		return MetaFingerprint{}, err_stat
+	}
+	checksum, err_checksum := file_sha256(filename)
+	if err_checksum != nil {
+		return MetaFingerprint{}, err_checksum
+	}
+	return MetaFingerprint{
+		Path:    relative_path,
+		Size:    stats.Size(),
+		ModTime: stats.ModTime().UnixNano(),
+		Sha256:  checksum,
+	}, nil
+}
+
+// Returns fingerprints of the image files of an entry whose metadata
+// is filled in from the files when the entry is read.
+func entry_image_fingerprints(
+	fs_directory string, entry_key string) ([]MetaFingerprint, error) {
+	data, err_meta := ReadMetaBytes(filepath.Join(fs_directory, entry_key))
+	if err_meta != nil {
+		return nil, err_meta
+	}
+	var meta EntryMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	var filenames []string
+	collect := func(info *assets.ImageInfoMeta) error {
+		if info.Filename != "" && !image_info_complete(*info) {
+			filenames = append(filenames, info.Filename)
+		}
+		return nil
+	}
+	// Only images are collected here, so validation errors of the
+	// assets are left for reading the entry.
+	for _, asset := range append(meta.Assets, meta.Asset) {
+		if asset.Type != "" {
+			update_asset_images(&asset, collect)
+		}
+	}
+	collect(&meta.Thumbnails.Default)
+	for index := range meta.Thumbnails.Sources {
+		collect(&meta.Thumbnails.Sources[index])
+	}
+	var result []MetaFingerprint
+	for _, filename := range filenames {
+		fingerprint, err := read_fingerprint(
+			fs_directory, path.Join(entry_key, filepath.ToSlash(filename)))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fingerprint)
+	}
+	return result, nil
+}
+
+// Returns fingerprints of the section meta.json file, meta.json files
+// of all entries that it lists, and image files that the entry
+// metadata is filled in from.
+func SectionFingerprints(fs_directory string) ([]MetaFingerprint, error) {
+	section_fingerprint, err_section := read_fingerprint(
+		fs_directory, "meta.json")
+	if err_section != nil {
+		return nil, err_section
+	}
+	meta, err_meta := read_section_meta(fs_directory)
+	if err_meta != nil {
+		return nil, err_meta
+	}
+	result := []MetaFingerprint{section_fingerprint}
+	for _, entry_key := range meta.Entries {
+		fingerprint, err := read_fingerprint(
+			fs_directory, path.Join(entry_key, "meta.json"))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fingerprint)
+		images, err_images := entry_image_fingerprints(fs_directory, entry_key)
+		if err_images != nil {
+			return nil, err_images
+		}
+		result = append(result, images...)
+	}
+	return result, nil
+}
+
+// Checks that metadata files have not changed since their fingerprints
+// were taken. Files with a different modification time are compared
+// by their contents, as copying files does not always keep the time.
+// Fingerprints of files with the same contents get the new time and
+// true is returned, so that callers can store them and avoid reading
+// the files again.
+func CheckFingerprints(
+	fs_directory string, fingerprints []MetaFingerprint) (bool, error) {
+	if len(fingerprints) == 0 {
+		return false, fmt.Errorf("No metadata fingerprints for %s", fs_directory)
+	}
+	refreshed := false
+	for index, fingerprint := range fingerprints {
+		filename := filepath.Join(
+			fs_directory, filepath.FromSlash(fingerprint.Path))
+		stats, err_stat := os.Stat(filename)
+		if err_stat != nil {
+			return false, err_stat
+		}
+		if stats.Size() != fingerprint.Size {
+			return false, fmt.Errorf("%s has changed", filename)
+		}
+		if stats.ModTime().UnixNano() == fingerprint.ModTime {
+			continue
+		}
+		checksum, err_checksum := file_sha256(filename)
+		if err_checksum != nil {
+			return false, err_checksum
+		}
+		if checksum != fingerprint.Sha256 {
+			return false, fmt.Errorf("%s has changed", filename)
+		}
+		fingerprints[index].ModTime = stats.ModTime().UnixNano()
+		refreshed = true
+	}
+	return refreshed, nil
+}
+
 func ReadSectionMetaCache(fs_directory string) (*base.Section, error) {
 	source := filepath.Join(fs_directory, "meta.aggregate.gob")
 	input, err_input := os.Open(source)
 	if err_input != nil {
 		return nil, fmt.Errorf("No cached metadata file exists: %s", source)
 	}
+	defer input.Close()
 	decoder := gob.NewDecoder(input)
-	var result base.Section
-	if err := decoder.Decode(&result); err != nil {
+	var cache section_cache
+	if err := decoder.Decode(&cache); err != nil {
 		defer os.Remove(source)
 		return nil, fmt.Errorf(
 			"Error while reading metadata file %s, removing: %s", source, err)
 	}
-
-	// Sanity check to see that cached metadata corresponds to the
-	// latest section metadata:
-	section_meta, err_meta := read_section_meta(fs_directory)
-	if err_meta != nil {
-		return nil, err_meta
+	if cache.Version != CACHE_FORMAT_VERSION ||
+		cache.Schema != get_cache_schema() {
+		return nil, fmt.Errorf(
+			"Cached metadata file %s is from a different program version",
+			source)
 	}
-
-	if len(section_meta.Entries) != len(result.Entries) {
+	refreshed, err_check := CheckFingerprints(fs_directory, cache.Fingerprints)
+	if err_check != nil {
 		return nil, fmt.Errorf(
-			"The amount of cached entries differs from the read entries at %s",
-			fs_directory)
+			"Cached metadata file %s is out of date: %v", source, err_check)
 	}
-	for entry_index, entry_key := range section_meta.Entries {
-		cached_entry := result.Entries[entry_index]
-		if entry_key != cached_entry.Key {
-			return nil, fmt.Errorf(
-				"Cached entry at index %d is not correct %s != %s",
-				entry_index,
-				entry_key,
-				cached_entry.Key)
+	if refreshed {
+		err := WriteSectionMetaCache(
+			fs_directory, &cache.Section, cache.Fingerprints)
+		if err != nil {
+			log.Printf("Failed to refresh cached metadata file %s: %v", source, err)
 		}
 	}
-
-	return &result, nil
+	return &cache.Section, nil
 }
 
+// Writes an aggregate metadata cache of a section. Fingerprints need
+// to be taken before the section is read, so that files changing
+// during the read make the cache out of date instead of wrong.
 func WriteSectionMetaCache(
-	fs_directory string, section *base.Section) error {
+	fs_directory string,
+	section *base.Section,
+	fingerprints []MetaFingerprint) error {
 	var tmpfile *os.File
 	{
 		_tmpfile, err := ioutil.TempFile(fs_directory, ".aggregate.gob")
@@ -321,8 +1168,18 @@ func WriteSectionMetaCache(
 	}
 	defer os.Remove(tmpfile.Name())
 	encoder := gob.NewEncoder(tmpfile)
-	if err := encoder.Encode(*section); err != nil {
-		return err
+	cache := section_cache{
+		Version:      CACHE_FORMAT_VERSION,
+		Schema:       get_cache_schema(),
+		Fingerprints: fingerprints,
+		Section:      *section,
+	}
+	err_encode := encoder.Encode(cache)
+	if err := tmpfile.Close(); err != nil && err_encode == nil {
+		err_encode = err
+	}
+	if err_encode != nil {
+		return err_encode
 	}
 	target := filepath.Join(fs_directory, "meta.aggregate.gob")
 	if err := os.Rename(tmpfile.Name(), target); err != nil {
@@ -340,6 +1197,10 @@ func ReadSectionMetaFiles(
 	if err_meta != nil {
 		return nil, err_meta
 	}
+	if meta.Family != "" && !VALID_KEY.MatchString(meta.Family) {
+		return nil, fmt.Errorf(
+			"Section %s has invalid competition family %s", key, meta.Family)
+	}
 	var entries []*base.Entry
 	for _, entry_key := range meta.Entries {
 		entry_fs_directory := filepath.Join(fs_directory, entry_key)
@@ -359,17 +1220,63 @@ func ReadSectionMetaFiles(
 		Description: meta.Description,
 		IsRanked:    meta.IsRanked,
 		IsOngoing:   meta.IsOngoing,
+		Family:      meta.Family,
 		Entries:     entries,
 	}
 	return &result, nil
 }
 
+// Checks that entry placements of a ranked section agree with the
+// entry order. Ongoing competitions do not have results yet, so
+// they are not checked. Warnings are reported by imports and
+// validation instead of every time the section is read.
+func RankingWarnings(section *base.Section) []string {
+	if !section.IsRanked || section.IsOngoing {
+		return nil
+	}
+	var warnings []string
+	var unranked []string
+	var previous *base.Entry
+	for _, entry := range section.Entries {
+		placement := entry.Ranking.Placement
+		if placement == 0 {
+			unranked = append(unranked, entry.Key)
+			continue
+		}
+		if previous != nil {
+			previous_placement := previous.Ranking.Placement
+			if placement < previous_placement {
+				warnings = append(warnings, fmt.Sprintf(
+					"Entry %s placed %d is after entry %s placed %d",
+					entry.Key,
+					placement,
+					previous.Key,
+					previous_placement))
+			} else if placement == previous_placement &&
+				!(entry.Ranking.SharedPlace && previous.Ranking.SharedPlace) {
+				warnings = append(warnings, fmt.Sprintf(
+					"Entries %s and %s have placement %d without a shared place",
+					previous.Key,
+					entry.Key,
+					placement))
+			}
+		}
+		previous = entry
+	}
+	if len(unranked) > 0 {
+		warnings = append(warnings, fmt.Sprintf(
+			"Ranked section has entries without placement: %s",
+			strings.Join(unranked, ", ")))
+	}
+	return warnings
+}
+
 func ReadSection(
 	fs_directory string,
 	data_path string,
 	path_prefix string,
 	key string) (*base.Section, error) {
-	_, err_key := regexp.MatchString("^[a-z]([a-z0-9]+-)*[a-z0-9]+$", key)
+	_, err_key := regexp.MatchString(VALID_KEY.String(), key)
 	if err_key != nil {
 		return nil, fmt.Errorf(
 			"Section for %s has invalid key %s", path_prefix, key)
@@ -382,38 +1289,49 @@ func ReadSection(
 		log.Println(err_section)
 	}
 
+	fingerprints, err_fingerprints := SectionFingerprints(fs_directory)
 	section, err_section := ReadSectionMetaFiles(
 		fs_directory, data_path, path_prefix, key)
 	if err_section != nil {
 		return nil, err_section
 	}
-	err_meta_write := WriteSectionMetaCache(fs_directory, section)
+	if err_fingerprints != nil {
+		log.Println(err_fingerprints)
+		return section, nil
+	}
+	err_meta_write := WriteSectionMetaCache(fs_directory, section, fingerprints)
 	if err_meta_write != nil {
 		log.Println(err_meta_write)
 	}
 	return section, nil
 }
 
-func get_entry_image(
-	directory string,
+// Creates an asset with complete file paths from asset metadata that
+// was read without knowing the entry location.
+func get_entry_asset(
+	data_path string,
 	fs_directory string,
-	meta ImageInfoMeta) base.ImageInfo {
-	image_path := meta.Filename
-	if directory != "" {
-		image_path = path.Clean(fmt.Sprintf("%s/%s", directory, meta.Filename))
-	}
-	fs_path := meta.Filename
-	if fs_directory != "" {
-		fs_path = path.Clean(fmt.Sprintf("%s/%s", fs_directory, meta.Filename))
-	}
-	result := base.ImageInfo{
-		Path:     image_path,
-		FsPath:   fs_path,
-		Checksum: meta.Checksum,
-		Size:     meta.Size,
-		Type:     meta.Type,
+	meta EntryAsset) base.Asset {
+	asset_type, _ := assets.Get(meta.Type)
+	return base.Asset{
+		Type:  meta.Type,
+		Title: meta.Title,
+		Data:  asset_type.Locate(meta.Data, data_path, fs_directory),
 	}
-	return result
+}
+
+// Returns a thumbnail from the primary asset for entries that do not
+// include their own thumbnails.
+func get_asset_thumbnail(asset base.Asset) (base.ImageInfo, bool) {
+	asset_type, ok := assets.Get(asset.Type)
+	if !ok {
+		return base.ImageInfo{}, false
+	}
+	provider, ok := asset_type.(assets.ThumbnailProvider)
+	if !ok {
+		return base.ImageInfo{}, false
+	}
+	return provider.Thumbnail(asset.Data)
 }
 
 func ReadEntry(
@@ -421,7 +1339,7 @@ func ReadEntry(
 	data_path string,
 	path_prefix string,
 	key string) (*base.Entry, error) {
-	_, err_key := regexp.MatchString("^[a-z]([a-z0-9]+-)*[a-z0-9]+$", key)
+	_, err_key := regexp.MatchString(VALID_KEY.String(), key)
 	if err_key != nil {
 		return nil, fmt.Errorf(
 			"Entry for %s has invalid key %s", path_prefix, key)
@@ -439,57 +1357,148 @@ func ReadEntry(
 	if err_unmarshal != nil {
 		return nil, fmt.Errorf("%s: %v", key, err_unmarshal)
 	}
-	if err := validate_image_info_meta(meta.Thumbnails.Default); err != nil {
-		return nil, fmt.Errorf("%s: %v", key, err)
+	// Entries can have either a single asset or a list of assets where
+	// the first one is the primary asset.
+	asset_metas := meta.Assets
+	if meta.Asset.Type != "" {
+		if len(meta.Assets) > 0 {
+			return nil, fmt.Errorf(
+				"%s: Entry can not have both asset and assets defined", key)
+		}
+		asset_metas = []EntryAsset{meta.Asset}
+	}
+	var entry_assets []base.Asset
+	for _, asset_meta := range asset_metas {
+		err_update := update_asset_images(
+			&asset_meta,
+			func(info *assets.ImageInfoMeta) error {
+				return update_image_info(fs_directory, info, false)
+			})
+		if err_update != nil {
+			return nil, fmt.Errorf("%s: %v", key, err_update)
+		}
+		entry_assets = append(
+			entry_assets, get_entry_asset(data_path, fs_directory, asset_meta))
+	}
+	var primary_asset base.Asset
+	if len(entry_assets) > 0 {
+		primary_asset = entry_assets[0]
+	}
+
+	var thumbnail_default base.ImageInfo
+	has_thumbnail := false
+	if meta.Thumbnails.Default.Filename == "" {
+		thumbnail_default, has_thumbnail = get_asset_thumbnail(primary_asset)
+	}
+	if !has_thumbnail {
+		err_update := update_image_info(
+			fs_directory, &meta.Thumbnails.Default, false)
+		if err_update != nil {
+			return nil, fmt.Errorf("%s: %v", key, err_update)
+		}
+		err := assets.ValidateImageInfoMeta(meta.Thumbnails.Default)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		thumbnail_default = assets.LocateImage(
+			data_path, fs_directory, meta.Thumbnails.Default)
 	}
 	image_sources := make([]base.ImageInfo, len(meta.Thumbnails.Sources))
 	for index, image := range meta.Thumbnails.Sources {
-		if err := validate_image_info_meta(image); err != nil {
+		if err := update_image_info(fs_directory, &image, false); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		if err := assets.ValidateImageInfoMeta(image); err != nil {
 			return nil, fmt.Errorf("Source image error %s: %v", key, err)
 		}
-		image_sources[index] = get_entry_image(
+		image_sources[index] = assets.LocateImage(
 			data_path, fs_directory, image)
 	}
+	files, err_files := read_download_files(
+		data_path, fs_directory, meta.Files)
+	if err_files != nil {
+		return nil, fmt.Errorf("%s: %v", key, err_files)
+	}
+	if err := validate_credits(meta.Credits); err != nil {
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+	if meta.Placement < 0 {
+		return nil, fmt.Errorf(
+			"%s: Placement %d is not a valid one", key, meta.Placement)
+	}
+	ranking := base.Ranking{
+		Placement:   meta.Placement,
+		SharedPlace: meta.SharedPlace,
+	}
+	if meta.Points != nil {
+		ranking.Points = *meta.Points
+		ranking.HasPoints = true
+	}
+	// Plain author field takes precedence so that entries can have
+	// an author text that differs from the structured credits.
+	author := meta.Author
+	if author == "" {
+		author = base.CreditsTitle(meta.Credits)
+	}
 	result := base.Entry{
 		Key:         key,
 		Path:        path_prefix,
 		Title:       meta.Title,
-		Author:      meta.Author,
+		Author:      author,
+		Credits:     meta.Credits,
+		Ranking:     ranking,
 		Description: meta.Description,
-		Asset: base.Asset{
-			Type: meta.Asset.Type,
-			Data: meta.Asset.Data,
-		},
+		Asset:       primary_asset,
 		Thumbnails: base.Thumbnails{
-			Default: get_entry_image(
-				data_path, fs_directory, meta.Thumbnails.Default),
+			Default: thumbnail_default,
 			Sources: image_sources,
 		},
 		ExternalLinks: meta.ExternalLinks,
+		Files:         files,
 	}
-	// Adjust the incomplete path:
-	if result.Asset.Type == "image" {
-		asset_data := result.Asset.Data.(ImageAsset)
-		asset_data.Default.Path = fmt.Sprintf(
-			"%s/%s", data_path, asset_data.Default.Path)
-		asset_data.Default.FsPath = fmt.Sprintf(
-			"%s/%s", fs_directory, asset_data.Default.Path)
-		result.Asset.Data = asset_data
-		for index, _ := range asset_data.Sources {
-			asset_data.Sources[index].Path = fmt.Sprintf(
-				"%s/%s", data_path, asset_data.Sources[index].Path)
-			asset_data.Sources[index].FsPath = fmt.Sprintf(
-				"%s/%s", fs_directory, asset_data.Sources[index].Path)
-		}
+	if len(entry_assets) > 1 {
+		result.ExtraAssets = entry_assets[1:]
 	}
 	return &result, nil
 }
 
 func register_gob_interfaces() {
 	gob.Register(base.Section{})
-	gob.Register(YoutubeAsset{})
-	gob.Register(ImageAsset{})
-	gob.Register(VimeoAsset{})
+	assets.RegisterGob()
+}
+
+// Reads a year with the given key from the site data directory but
+// leaves out the sections that fail to load and returns them as load errors. Year that fails to
+// load as a whole is returned as a load error without sections.
+func read_site_year_degraded(
+	fs_directory string,
+	site_root string,
+	key string) (*base.Year, []LoadError) {
+	year_dir := filepath.Join(fs_directory, key)
+	var load_errors []LoadError
+	year, err_year := read_year(
+		year_dir,
+		fmt.Sprintf("%s/_data/%s", site_root, key),
+		fmt.Sprintf("%s/%s", site_root, key),
+		key,
+		func(section string, sections []string, err error) error {
+			load_errors = append(load_errors, LoadError{
+				Year:     key,
+				Section:  section,
+				Path:     filepath.Join(year_dir, section),
+				Message:  err.Error(),
+				sections: sections,
+			})
+			return nil
+		})
+	if err_year != nil {
+		return nil, []LoadError{{
+			Year:    key,
+			Path:    year_dir,
+			Message: err_year.Error(),
+		}}
+	}
+	return year, load_errors
 }
 
 func New(fs_directory string, site_root string) (*SiteState, error) {
@@ -510,24 +1519,28 @@ func New(fs_directory string, site_root string) (*SiteState, error) {
 		year_candidates = append(year_candidates, info.Name())
 	}
 	sort.Sort(sort.Reverse(sort.StringSlice(year_candidates)))
+	// One broken upload should not keep the whole archive offline, so
+	// years and sections that fail to load are quarantined and the
+	// rest of the archive is served.
 	var years []*base.Year
+	var load_errors []LoadError
 	for _, year_candidate := range year_candidates {
-		year_dir := filepath.Join(fs_directory, year_candidate)
-		year_data := fmt.Sprintf("%s/_data/%s", site_root, year_candidate)
-		year_prefix := fmt.Sprintf("%s/%s", site_root, year_candidate)
-		year, err := ReadYear(year_dir, year_data, year_prefix, year_candidate)
-		if err != nil {
-			return nil, err
+		year, year_errors := read_site_year_degraded(
+			fs_directory, site_root, year_candidate)
+		for _, load_error := range year_errors {
+			log.Printf("Quarantined %s: %s", load_error.Path, load_error.Message)
 		}
+		load_errors = append(load_errors, year_errors...)
 		if year == nil {
 			continue
 		}
 		years = append(years, year)
 	}
 	state := SiteState{
-		SiteRoot: site_root,
-		DataDir:  fs_directory,
-		Years:    years,
+		SiteRoot:    site_root,
+		DataDir:     fs_directory,
+		years:       years,
+		load_errors: load_errors,
 	}
 	return &state, nil
 }
@@ -0,0 +1,202 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"state"
+	"strings"
+	"time"
+)
+
+var YEAR_DIRECTORY = regexp.MustCompile("^[0-9]{4}$")
+
+// Modification information of a single meta.json file. A file is
+// considered to be changed when any of these values differ between
+// two polls.
+type file_stamp struct {
+	ModTime int64
+	Size    int64
+}
+
+// Year or a section that needs to be reloaded. Empty section means
+// that the whole year is reloaded.
+type reload_target struct {
+	Year    string
+	Section string
+}
+
+// Polls the data directory for changed meta.json files and reloads
+// the years and sections that they belong to. Bursts of changes are
+// collected together and reloaded only after a full poll interval
+// has passed without any new changes.
+type Watcher struct {
+	State    *state.SiteState
+	Interval time.Duration
+	files    map[string]file_stamp
+	pending  map[reload_target]bool
+}
+
+func stat_meta(files map[string]file_stamp, directory string) {
+	meta_path := filepath.Join(directory, "meta.json")
+	info, err := os.Stat(meta_path)
+	if err != nil {
+		return
+	}
+	files[meta_path] = file_stamp{
+		ModTime: info.ModTime().UnixNano(),
+		Size:    info.Size(),
+	}
+}
+
+func list_directories(directory string) []string {
+	infos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil
+	}
+	var result []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		// Skips temporary API directories and other hidden files.
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		result = append(result, info.Name())
+	}
+	return result
+}
+
+// Finds all year, section, and entry meta.json files under the data
+// directory.
+func scan_meta_files(data_dir string) map[string]file_stamp {
+	files := make(map[string]file_stamp)
+	for _, year := range list_directories(data_dir) {
+		if !YEAR_DIRECTORY.MatchString(year) {
+			continue
+		}
+		year_dir := filepath.Join(data_dir, year)
+		stat_meta(files, year_dir)
+		for _, section := range list_directories(year_dir) {
+			section_dir := filepath.Join(year_dir, section)
+			stat_meta(files, section_dir)
+			for _, entry := range list_directories(section_dir) {
+				stat_meta(files, filepath.Join(section_dir, entry))
+			}
+		}
+	}
+	return files
+}
+
+// Maps a changed meta.json file into the year or section that needs
+// to be reloaded.
+func (w *Watcher) get_reload_target(meta_path string) (reload_target, bool) {
+	relative, err_rel := filepath.Rel(w.State.DataDir, meta_path)
+	if err_rel != nil {
+		return reload_target{}, false
+	}
+	parts := strings.Split(filepath.ToSlash(relative), "/")
+	if len(parts) < 2 {
+		return reload_target{}, false
+	}
+	year := parts[0]
+	// Year level meta.json changes and sections of years that have
+	// not been loaded yet require reloading the whole year.
+	if len(parts) == 2 || w.State.GetYear(year) == nil {
+		return reload_target{Year: year}, true
+	}
+	return reload_target{Year: year, Section: parts[1]}, true
+}
+
+func (w *Watcher) add_pending(meta_path string) {
+	target, ok := w.get_reload_target(meta_path)
+	if !ok {
+		return
+	}
+	w.pending[target] = true
+}
+
+// Reloads all pending years and sections. Sections of years that are
+// reloaded as a whole are skipped.
+func (w *Watcher) reload_pending() {
+	var targets []reload_target
+	reload_years := make(map[string]bool)
+	for target := range w.pending {
+		if target.Section == "" {
+			reload_years[target.Year] = true
+		}
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Year != targets[j].Year {
+			return targets[i].Year < targets[j].Year
+		}
+		return targets[i].Section < targets[j].Section
+	})
+	for _, target := range targets {
+		var err error
+		if target.Section == "" {
+			log.Printf("Reloading changed year %s", target.Year)
+			err = w.State.UpdateYear(target.Year)
+		} else if !reload_years[target.Year] {
+			log.Printf(
+				"Reloading changed section %s/%s", target.Year, target.Section)
+			err = w.State.UpdateSection(target.Year, target.Section)
+		}
+		if err != nil {
+			log.Printf(
+				"Unable to reload %s/%s: %s", target.Year, target.Section, err)
+		}
+	}
+	w.pending = make(map[reload_target]bool)
+}
+
+// Does one scan of the data directory. Changes are first collected as
+// pending reloads and they are reloaded on the first poll that does
+// not detect any new changes.
+func (w *Watcher) Poll() {
+	files := scan_meta_files(w.State.DataDir)
+	changed := false
+	for meta_path, stamp := range files {
+		if old_stamp, ok := w.files[meta_path]; ok && old_stamp == stamp {
+			continue
+		}
+		w.add_pending(meta_path)
+		changed = true
+	}
+	for meta_path := range w.files {
+		if _, ok := files[meta_path]; ok {
+			continue
+		}
+		w.add_pending(meta_path)
+		changed = true
+	}
+	w.files = files
+	if !changed && len(w.pending) > 0 {
+		w.reload_pending()
+	}
+}
+
+// Polls the data directory forever.
+func (w *Watcher) Run() {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		w.Poll()
+	}
+}
+
+// Creates a watcher for the data directory of the given state. The
+// current data directory contents are considered to be already loaded.
+func New(site_state *state.SiteState, interval time.Duration) *Watcher {
+	return &Watcher{
+		State:    site_state,
+		Interval: interval,
+		files:    scan_meta_files(site_state.DataDir),
+		pending:  make(map[reload_target]bool),
+	}
+}
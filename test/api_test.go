@@ -3,9 +3,12 @@ package import_test
 import (
 	"api"
 	"archive/tar"
+	"assets"
 	"base"
 	"bytes"
 	"compress/gzip"
+	"image"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -49,6 +52,22 @@ func do_request(t *testing.T, path string, body io.Reader) (*base.SiteSettings,
 	return settings, resp
 }
 
+func do_state_request(
+	t *testing.T,
+	settings *base.SiteSettings,
+	site_state *state.SiteState,
+	method string,
+	path string,
+	body io.Reader) *http.Response {
+	renderer := api.Renderer(*settings, site_state)
+	handler := server.StripPrefix("/api/", renderer)
+	url := "http://example.com/api/" + path
+	req := httptest.NewRequest(method, url, body)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w.Result()
+}
+
 func require_http_status(t *testing.T, resp *http.Response, status_code int) {
 	body, _ := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode != status_code {
@@ -204,3 +223,137 @@ func TestIndividualSectionUpdateShouldBeVisible(t *testing.T) {
 		"2001/section/entry/meta.json",
 	})
 }
+
+func TestReloadShouldReadManuallyUpdatedSection(t *testing.T) {
+	setup(t)
+	settings := create_site_layout(t)
+	site_state := state.SiteState{DataDir: settings.DataDir}
+	year_data := create_tarball(t, YEAR_WITH_SECTION)
+	{
+		resp := do_state_request(
+			t, settings, &site_state, "PUT", "2001", year_data)
+		require_http_status(t, resp, http.StatusOK)
+	}
+
+	section_meta := filepath.Join(
+		settings.DataDir, "2001", "section", "meta.json")
+	err_write := ioutil.WriteFile(
+		section_meta, []byte(`{"name": "New name", "entries": []}`), 0600)
+	if err_write != nil {
+		t.Fatal(err_write)
+	}
+	resp := do_state_request(
+		t, settings, &site_state, "POST", "2001/section", nil)
+	require_http_status(t, resp, http.StatusOK)
+	section_name := site_state.GetYear("2001").Sections[0].Name
+	if section_name != "New name" {
+		t.Errorf("Section was not reloaded, got name '%s'", section_name)
+	}
+}
+
+func TestUnlistedSectionUpdateShouldResultInBadRequest(t *testing.T) {
+	setup(t)
+	settings := create_site_layout(t)
+	site_state := state.SiteState{DataDir: settings.DataDir}
+	year_data := create_tarball(t, YEAR_WITH_SECTION)
+	{
+		resp := do_state_request(
+			t, settings, &site_state, "PUT", "2001", year_data)
+		require_http_status(t, resp, http.StatusOK)
+	}
+	orphan_dir := filepath.Join(settings.DataDir, "2001", "orphan")
+	if err := os.MkdirAll(orphan_dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	section_data := create_tarball(t, SECTION_WITH_ENTRY)
+	resp := do_state_request(
+		t, settings, &site_state, "PUT", "2001/orphan", section_data)
+	require_http_status(t, resp, http.StatusBadRequest)
+	if _, err := os.Stat(filepath.Join(orphan_dir, "meta.json")); err == nil {
+		t.Error("Unlisted section was written to disk")
+	}
+}
+
+func TestReloadOfMissingYearShouldResultInBadRequest(t *testing.T) {
+	setup(t)
+	settings := create_site_layout(t)
+	site_state := state.SiteState{DataDir: settings.DataDir}
+	resp := do_state_request(t, settings, &site_state, "POST", "2001", nil)
+	require_http_status(t, resp, http.StatusBadRequest)
+}
+
+func create_year_with_download(checksum string) []TarEntry {
+	return []TarEntry{
+		{"meta.json", `{"sections": ["section"]}`},
+		{"section/meta.json", `{"name": "Name", "entries": ["entry"]}`},
+		{"section/entry/meta.json", `{
+"title": "Title",
+"files": [{"filename": "demo.zip", "size": 12, "sha256": "` + checksum + `", "platform": "Windows"}],
+"thumbnails": {"default": {"filename": "thumb.png", "type": "image/png", "checksum": "abcdef", "size": {"x": 160, "y": 90}}}
+}`},
+		{"section/entry/demo.zip", "demo archive"},
+	}
+}
+
+func TestDownloadWithMatchingChecksumShouldResultInStatusOk(t *testing.T) {
+	setup(t)
+	year_data := create_tarball(t, create_year_with_download(
+		"3f7168a1c75aaf2a5b839630fc201f27f365ffa4c181338232cd611015b1eb1b"))
+	settings, resp := do_request(t, "2001", year_data)
+	require_http_status(t, resp, http.StatusOK)
+	require_files(t, settings, []string{
+		"2001/section/entry/demo.zip",
+	})
+}
+
+func TestDownloadWithWrongChecksumShouldResultInBadRequest(t *testing.T) {
+	setup(t)
+	year_data := create_tarball(t, create_year_with_download(
+		"3f7168a1c75aaf2a5b839630fc201f27f365ffa4c181338232cd611015b1eb10"))
+	_, resp := do_request(t, "2001", year_data)
+	require_http_status(t, resp, http.StatusBadRequest)
+}
+
+func create_png(t *testing.T, width int, height int) string {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestOriginalImageShouldBeResizedOnImport(t *testing.T) {
+	setup(t)
+	settings := create_site_layout(t)
+	settings.ImageWidths = []int{200, 400}
+	site_state := state.SiteState{DataDir: settings.DataDir}
+	year_data := create_tarball(t, []TarEntry{
+		{"meta.json", `{"sections": ["section"]}`},
+		{"section/meta.json", `{"name": "Name", "entries": ["entry"]}`},
+		{"section/entry/meta.json", `{"title": "Title", "original": "photo.png"}`},
+		{"section/entry/photo.png", create_png(t, 600, 400)},
+	})
+	resp := do_state_request(t, settings, &site_state, "PUT", "2001", year_data)
+	require_http_status(t, resp, http.StatusOK)
+
+	entry := site_state.GetYear("2001").Sections[0].Entries[0]
+	photo, ok := entry.Asset.Data.(assets.ImageAsset)
+	if !ok {
+		t.Fatalf("Entry asset is not an image: %v", entry.Asset)
+	}
+	if len(photo.Sources) != 2 || photo.Default.Size.X != 400 {
+		t.Errorf("Unexpected image sources %v", photo.Sources)
+	}
+	if photo.Default.Checksum == "" || photo.Default.Type != "image/png" {
+		t.Errorf("Unexpected default image %v", photo.Default)
+	}
+	if entry.Thumbnails.Default.Size.X != 160 {
+		t.Errorf("Unexpected thumbnail %v", entry.Thumbnails.Default)
+	}
+	require_files(t, settings, []string{
+		"2001/section/entry/image-400w.png",
+		"2001/section/entry/thumbnail-160w.png",
+	})
+}
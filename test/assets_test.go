@@ -0,0 +1,139 @@
+package assets_test
+
+import (
+	"assets"
+	"base"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"state"
+	"testing"
+)
+
+type Slideshow struct {
+	Slides []string
+}
+
+type slideshow_type struct{}
+
+func (slideshow_type) Name() string {
+	return "slideshow"
+}
+
+func (slideshow_type) DefaultTitle() string {
+	return "Slides"
+}
+
+func (slideshow_type) Decode(data []byte) (interface{}, error) {
+	var meta Slideshow
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (slideshow_type) Validate(meta interface{}) error {
+	if len(meta.(Slideshow).Slides) == 0 {
+		return fmt.Errorf("Slideshow does not have any slides")
+	}
+	return nil
+}
+
+func (slideshow_type) Locate(
+	meta interface{}, data_path string, fs_directory string) interface{} {
+	slideshow := meta.(Slideshow)
+	var slides []string
+	for _, slide := range slideshow.Slides {
+		slides = append(slides, data_path+"/"+slide)
+	}
+	return Slideshow{Slides: slides}
+}
+
+func (slideshow_type) RegisterGob() {
+}
+
+func (slideshow_type) Render(
+	context assets.RenderContext, data interface{}) string {
+	return fmt.Sprintf("%d slides", len(data.(Slideshow).Slides))
+}
+
+func init() {
+	assets.Register(slideshow_type{})
+}
+
+func create_entry_dir(t *testing.T, meta string) string {
+	entry_dir := filepath.Join(t.Name(), "entry")
+	if err := os.RemoveAll(entry_dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(entry_dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	meta_path := filepath.Join(entry_dir, "meta.json")
+	if err := ioutil.WriteFile(meta_path, []byte(meta), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return entry_dir
+}
+
+func TestRegisteredAssetTypeShouldBeReadFromEntry(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "slideshow", "data": {"slides": ["1.png", "2.png"]}},
+"thumbnails": {"default": {
+  "filename": "thumb.png", "type": "image/png",
+  "checksum": "abcdef", "size": {"x": 160, "y": 90}}}
+}`)
+	entry, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slideshow, ok := entry.Asset.Data.(Slideshow)
+	if !ok {
+		t.Fatalf("Asset data is not a slideshow: %v", entry.Asset.Data)
+	}
+	if slideshow.Slides[1] != "/_data/2001/section/entry/2.png" {
+		t.Errorf("Unexpected slide path %s", slideshow.Slides[1])
+	}
+}
+
+func TestRegisteredAssetTypeShouldBeValidated(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "slideshow", "data": {"slides": []}},
+"thumbnails": {"default": {
+  "filename": "thumb.png", "type": "image/png",
+  "checksum": "abcdef", "size": {"x": 160, "y": 90}}}
+}`)
+	_, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err == nil {
+		t.Fatal("Slideshow without slides did not result in an error")
+	}
+}
+
+func TestDuplicateAssetTypeShouldPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Registering an existing asset type did not panic")
+		}
+	}()
+	assets.Register(slideshow_type{})
+}
+
+func TestAssetDataShouldKeepGobNamesOfStatePackage(t *testing.T) {
+	assets.RegisterGob()
+	var buffer bytes.Buffer
+	asset := base.Asset{Type: "youtube", Data: assets.YoutubeAsset{Id: "abc"}}
+	if err := gob.NewEncoder(&buffer).Encode(asset); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buffer.Bytes(), []byte("state.YoutubeAsset")) {
+		t.Errorf("YouTube asset is not encoded as state.YoutubeAsset")
+	}
+}
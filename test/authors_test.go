@@ -0,0 +1,121 @@
+package authors_test
+
+import (
+	"authors"
+	"base"
+	"testing"
+)
+
+func TestKeyShouldNormaliseHandleSpellings(t *testing.T) {
+	for _, name := range []string{"Jml^vs", "JML vs", " jml-VS "} {
+		if key := authors.Key(name); key != "jml-vs" {
+			t.Errorf("Key of %q is %q, expected jml-vs", name, key)
+		}
+	}
+	if key := authors.Key("Äänet"); key != "aanet" {
+		t.Errorf("Diacritics were not folded: %q", key)
+	}
+	if key := authors.Key("^^"); key != "" {
+		t.Errorf("Name without letters got key %q", key)
+	}
+}
+
+func TestSplitNamesShouldSeparateCollaborators(t *testing.T) {
+	names := authors.SplitNames("Purple Motion & Skaven, Jml^vs")
+	expected := []string{"Purple Motion", "Skaven", "Jml^vs"}
+	if len(names) != len(expected) {
+		t.Fatalf("Got names %v, expected %v", names, expected)
+	}
+	for index, name := range names {
+		if name != expected[index] {
+			t.Errorf("Name %d is %q, expected %q", index, name, expected[index])
+		}
+	}
+}
+
+func TestAuthorEntriesShouldBeGroupedByYearAndSection(t *testing.T) {
+	demo := &base.Section{Key: "demo", Entries: []*base.Entry{
+		&base.Entry{Key: "first", Author: "Future Crew"},
+		&base.Entry{Key: "second", Author: "Future Crew & Purple Motion"},
+	}}
+	music := &base.Section{Key: "music", Entries: []*base.Entry{
+		&base.Entry{Key: "tune", Author: "Purple Motion, purple motion"},
+	}}
+	old_demo := &base.Section{Key: "demo", Entries: []*base.Entry{
+		&base.Entry{Key: "old", Author: "FUTURE CREW"},
+	}}
+	years := []*base.Year{
+		&base.Year{Key: "1993", Sections: []*base.Section{demo, music}},
+		&base.Year{Key: "1992", Sections: []*base.Section{old_demo}},
+	}
+	index := authors.New(years)
+
+	crew := index.Get("future-crew")
+	if crew == nil {
+		t.Fatal("Author future-crew was not found")
+	}
+	if crew.EntryCount != 3 {
+		t.Errorf("Expected 3 entries, got %d", crew.EntryCount)
+	}
+	if len(crew.Years) != 2 || crew.Years[1].Year.Key != "1992" {
+		t.Fatalf("Unexpected years %v", crew.Years)
+	}
+	if len(crew.Years[0].Sections[0].Entries) != 2 {
+		t.Errorf(
+			"Expected 2 entries in 1993 demo section, got %d",
+			len(crew.Years[0].Sections[0].Entries))
+	}
+
+	motion := index.Get("purple-motion")
+	if motion == nil {
+		t.Fatal("Author purple-motion was not found")
+	}
+	if motion.EntryCount != 2 {
+		t.Errorf(
+			"Same author listed twice should count once, got %d entries",
+			motion.EntryCount)
+	}
+	if index.Get("missing") != nil {
+		t.Error("Missing author was found")
+	}
+}
+
+func TestCreditsShouldLinkGroupsMembersAndAliases(t *testing.T) {
+	credits := base.Credits{
+		Groups: []base.Credit{{
+			Name: "Future Crew",
+			Members: []base.Credit{
+				{Name: "Purple Motion", Aliases: []string{"PM"}},
+			},
+		}},
+	}
+	demo := &base.Section{Key: "demo", Entries: []*base.Entry{
+		&base.Entry{Key: "plain", Author: "PM"},
+		&base.Entry{Key: "credited", Author: "Future Crew", Credits: credits},
+	}}
+	years := []*base.Year{
+		&base.Year{Key: "1993", Sections: []*base.Section{demo}},
+	}
+	index := authors.New(years)
+
+	group := index.Get("future-crew")
+	if group == nil || !group.IsGroup {
+		t.Fatal("Group future-crew was not found")
+	}
+	if len(group.Members) != 1 || group.Members[0].Key != "purple-motion" {
+		t.Fatalf("Unexpected members %v", group.Members)
+	}
+	person := index.Get("pm")
+	if person != group.Members[0] {
+		t.Fatal("Alias did not resolve to the credited person")
+	}
+	if person.Name != "Purple Motion" {
+		t.Errorf("Author is not named after the canonical name: %s", person.Name)
+	}
+	if person.EntryCount != 2 {
+		t.Errorf("Expected 2 entries, got %d", person.EntryCount)
+	}
+	if len(person.Groups) != 1 || person.Groups[0] != group {
+		t.Errorf("Unexpected groups %v", person.Groups)
+	}
+}
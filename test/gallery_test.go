@@ -0,0 +1,269 @@
+package gallery_test
+
+import (
+	"assets"
+	"base"
+	"bytes"
+	"encoding/json"
+	"gallery"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"images"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"state"
+	"testing"
+)
+
+func write_test_image(t *testing.T, filename string, width int, height int) {
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			source.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	output, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	if filepath.Ext(filename) == ".png" {
+		err = png.Encode(output, source)
+	} else {
+		err = jpeg.Encode(output, source, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResizeShouldAverageCoveredPixels(t *testing.T) {
+	source := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		value := uint8(0)
+		if x%2 == 1 {
+			value = 200
+		}
+		source.Set(x, 0, color.RGBA{value, value, value, 255})
+		source.Set(x, 1, color.RGBA{value, value, value, 255})
+	}
+	resized := images.Resize(source, base.Resolution{X: 2, Y: 1})
+	if resized.Bounds().Dx() != 2 || resized.Bounds().Dy() != 1 {
+		t.Fatalf("Unexpected size %v", resized.Bounds())
+	}
+	if pixel := resized.RGBAAt(1, 0); pixel.R != 100 || pixel.A != 255 {
+		t.Errorf("Unexpected averaged pixel %v", pixel)
+	}
+}
+
+func TestGenerateShouldWriteEntriesWithResizedImages(t *testing.T) {
+	os.RemoveAll(t.Name())
+	source_dir := filepath.Join(t.Name(), "photos")
+	section_dir := filepath.Join(t.Name(), "section")
+	if err := os.MkdirAll(source_dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	write_test_image(t, filepath.Join(source_dir, "Crowd_2019.jpg"), 800, 600)
+	write_test_image(t, filepath.Join(source_dir, "small.png"), 400, 100)
+	write_test_image(t, filepath.Join(source_dir, "notes.txt"), 1, 1)
+
+	keys, err := gallery.Generate(source_dir, section_dir, gallery.Options{
+		Name:   "Photos",
+		Widths: []int{320, 640},
+		Jobs:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "crowd-2019" || keys[1] != "small" {
+		t.Fatalf("Unexpected entry keys %v", keys)
+	}
+
+	entry_dir := filepath.Join(section_dir, "crowd-2019")
+	entry, err_entry := state.ReadEntry(
+		entry_dir, "/_data/2019/photos/crowd-2019", "/2019/photos/crowd-2019",
+		"crowd-2019")
+	if err_entry != nil {
+		t.Fatal(err_entry)
+	}
+	if entry.Title != "Crowd 2019" {
+		t.Errorf("Unexpected title %s", entry.Title)
+	}
+	photo := entry.Asset.Data.(assets.ImageAsset)
+	if len(photo.Sources) != 2 {
+		t.Fatalf("Expected 2 image sources, got %d", len(photo.Sources))
+	}
+	if photo.Default.Size != (base.Resolution{X: 640, Y: 480}) {
+		t.Errorf("Unexpected default image size %v", photo.Default.Size)
+	}
+	size, image_type, err_size := images.ReadSize(photo.Default.FsPath)
+	if err_size != nil {
+		t.Fatal(err_size)
+	}
+	if size != photo.Default.Size || image_type != "image/jpeg" {
+		t.Errorf("Image file does not match metadata: %v %s", size, image_type)
+	}
+	checksum, _ := base.CreateFileChecksum(photo.Default.FsPath)
+	if checksum != photo.Default.Checksum {
+		t.Errorf("Unexpected checksum %s", photo.Default.Checksum)
+	}
+	if entry.Thumbnails.Default.Size != gallery.THUMBNAIL_SIZE {
+		t.Errorf("Unexpected thumbnail size %v", entry.Thumbnails.Default.Size)
+	}
+	if len(entry.Thumbnails.Sources) != 1 {
+		t.Errorf(
+			"Expected 1 high density thumbnail, got %d",
+			len(entry.Thumbnails.Sources))
+	}
+
+	// Images narrower than the widest source get one in their own
+	// width and no upscaled thumbnails.
+	small, err_small := state.ReadEntry(
+		filepath.Join(section_dir, "small"),
+		"/_data/2019/photos/small", "/2019/photos/small", "small")
+	if err_small != nil {
+		t.Fatal(err_small)
+	}
+	small_photo := small.Asset.Data.(assets.ImageAsset)
+	if small_photo.Default.Size != (base.Resolution{X: 400, Y: 100}) {
+		t.Errorf("Unexpected default image size %v", small_photo.Default.Size)
+	}
+	if small_photo.Default.Type != "image/png" {
+		t.Errorf("Unexpected image type %s", small_photo.Default.Type)
+	}
+	if len(small.Thumbnails.Sources) != 0 {
+		t.Errorf(
+			"Expected no high density thumbnails, got %d",
+			len(small.Thumbnails.Sources))
+	}
+}
+
+func TestGenerateShouldKeepExistingSectionEntries(t *testing.T) {
+	os.RemoveAll(t.Name())
+	source_dir := filepath.Join(t.Name(), "photos")
+	section_dir := filepath.Join(t.Name(), "section")
+	for _, directory := range []string{source_dir, section_dir} {
+		if err := os.MkdirAll(directory, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write_test_image(t, filepath.Join(source_dir, "crowd.jpg"), 100, 100)
+	write_test_image(t, filepath.Join(source_dir, "stage.jpg"), 100, 100)
+	section_meta := filepath.Join(section_dir, "meta.json")
+	err_write := ioutil.WriteFile(
+		section_meta, []byte(`{"entries": ["intro", "stage"]}`), 0600)
+	if err_write != nil {
+		t.Fatal(err_write)
+	}
+
+	_, err := gallery.Generate(source_dir, section_dir, gallery.Options{
+		Widths: []int{100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err_read := ioutil.ReadFile(section_meta)
+	if err_read != nil {
+		t.Fatal(err_read)
+	}
+	var meta struct {
+		Entries []string
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Entries) != 3 ||
+		meta.Entries[0] != "intro" ||
+		meta.Entries[1] != "stage" ||
+		meta.Entries[2] != "crowd" {
+		t.Errorf("Unexpected entries %v", meta.Entries)
+	}
+}
+
+func TestGenerateShouldRemoveImagesOfEarlierWidths(t *testing.T) {
+	os.RemoveAll(t.Name())
+	source_dir := filepath.Join(t.Name(), "photos")
+	section_dir := filepath.Join(t.Name(), "section")
+	if err := os.MkdirAll(source_dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	write_test_image(t, filepath.Join(source_dir, "crowd.jpg"), 400, 300)
+	for _, width := range []int{320, 200} {
+		_, err := gallery.Generate(source_dir, section_dir, gallery.Options{
+			Widths: []int{width},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry_dir := filepath.Join(section_dir, "crowd")
+	if _, err := os.Stat(filepath.Join(entry_dir, "image-320w.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Image of an earlier width was not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(entry_dir, "image-200w.jpg")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeriveShouldRejectImagesOverPixelLimit(t *testing.T) {
+	os.RemoveAll(t.Name())
+	if err := os.MkdirAll(t.Name(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	original := filepath.Join(t.Name(), "large.png")
+	write_test_image(t, original, 200, 100)
+	_, _, err := gallery.Derive(original, t.Name(), gallery.Options{
+		MaxPixels: 200*100 - 1,
+	})
+	if err == nil {
+		t.Fatal("Image over the pixel limit was decoded")
+	}
+}
+
+func TestLoadShouldTurnJpegImagesUpright(t *testing.T) {
+	os.RemoveAll(t.Name())
+	if err := os.MkdirAll(t.Name(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	var encoded bytes.Buffer
+	source := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	// Left edge of the stored image is the top edge when upright.
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 16; x++ {
+			source.Set(x, y, color.White)
+		}
+	}
+	if err := jpeg.Encode(&encoded, source, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Camera was rotated 90 degrees clockwise, so the upright image is
+	// taller than it is wide.
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08" +
+		"\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00" +
+		"\x00\x00\x00\x00")
+	var data []byte
+	data = append(data, encoded.Bytes()[:2]...)
+	data = append(data, 0xff, 0xe1, 0, byte(len(exif)+2))
+	data = append(data, exif...)
+	data = append(data, encoded.Bytes()[2:]...)
+	filename := filepath.Join(t.Name(), "portrait.jpg")
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, _, err := images.Load(filename, images.DEFAULT_MAX_PIXELS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Bounds().Dx() != 32 || loaded.Bounds().Dy() != 64 {
+		t.Fatalf("Image was not turned upright: %v", loaded.Bounds())
+	}
+	top := loaded.RGBAAt(16, 4)
+	bottom := loaded.RGBAAt(16, 60)
+	if top.R < 200 || bottom.R > 50 {
+		t.Errorf("Image was turned the wrong way: %v %v", top, bottom)
+	}
+}
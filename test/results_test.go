@@ -0,0 +1,174 @@
+package results_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"results"
+	"state"
+	"strings"
+	"testing"
+)
+
+func TestParseShouldReadCsvWithHeader(t *testing.T) {
+	entries, err := results.Parse("results.csv", strings.NewReader(
+		"rank,points,title,author\n"+
+			"1,300,Second Reality,Future Crew\n"+
+			"2,200,\"Tune, part 2\",Purple Motion\n"+
+			"2,200,Other,Skaven\n"+
+			",,Disqualified,Someone\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(entries))
+	}
+	if entries[1].Title != "Tune, part 2" || entries[1].Points != 200 {
+		t.Errorf("Unexpected result %v", entries[1])
+	}
+	if entries[0].SharedPlace || !entries[1].SharedPlace || !entries[2].SharedPlace {
+		t.Error("Shared places were not detected")
+	}
+	if entries[3].Placement != 0 || entries[3].HasPoints {
+		t.Errorf("Unranked result has a placement: %v", entries[3])
+	}
+}
+
+func TestParseShouldReadPlainText(t *testing.T) {
+	entries, err := results.Parse("results.txt", strings.NewReader(
+		"PC demo competition results\n"+
+			"2019 Assembly Demo Competition results\n\n"+
+			" 1. 1234 pts Second Reality by Future Crew\n"+
+			" 2.  Unreal\n"+
+			"2. 4 Seasons by Foo\n"+
+			"3. Stand by Me by Bar\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(entries))
+	}
+	if entries[2].Title != "4 Seasons" || entries[2].HasPoints ||
+		entries[2].Author != "Foo" {
+		t.Errorf("Unexpected result %v", entries[2])
+	}
+	if entries[3].Title != "Stand by Me" || entries[3].Author != "Bar" {
+		t.Errorf("Unexpected result %v", entries[3])
+	}
+	if entries[0].Title != "Second Reality" ||
+		entries[0].Author != "Future Crew" ||
+		entries[0].Points != 1234 {
+		t.Errorf("Unexpected result %v", entries[0])
+	}
+	if entries[1].Title != "Unreal" || entries[1].HasPoints {
+		t.Errorf("Unexpected result %v", entries[1])
+	}
+}
+
+func TestEntryKeyShouldBeValidAndUnique(t *testing.T) {
+	used := map[string]bool{"second-reality": true}
+	expected := map[string]string{
+		"Äänet":          "aanet",
+		"2nd Reality":    "entry-2nd-reality",
+		"A-Team":         "entry-a-team",
+		"!!!":            "entry",
+		"Second Reality": "second-reality-2",
+	}
+	for title, key := range expected {
+		if result := state.EntryKey(title, used); result != key {
+			t.Errorf("Key of %q is %q, expected %q", title, result, key)
+		}
+	}
+}
+
+func write_json(t *testing.T, directory string, value interface{}) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(value)
+	target := filepath.Join(directory, "meta.json")
+	if err := ioutil.WriteFile(target, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read_json(t *testing.T, directory string) map[string]interface{} {
+	data, err := ioutil.ReadFile(filepath.Join(directory, "meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestImportShouldOrderEntriesAndReportMismatches(t *testing.T) {
+	section_dir := filepath.Join(t.Name(), "demo")
+	os.RemoveAll(t.Name())
+	write_json(t, section_dir, map[string]interface{}{
+		"name":    "Demo",
+		"entries": []string{"unreal", "dir-key", "unranked"},
+	})
+	write_json(t, filepath.Join(section_dir, "unreal"), map[string]interface{}{
+		"title": "Unreal", "description": "Kept",
+	})
+	write_json(t, filepath.Join(section_dir, "dir-key"), map[string]interface{}{
+		"title": "Second Reality",
+	})
+	write_json(t, filepath.Join(section_dir, "unranked"), map[string]interface{}{
+		"title": "Unranked", "placement": 5,
+	})
+	write_json(t, filepath.Join(section_dir, "stray"), map[string]interface{}{
+		"title": "Stray",
+	})
+
+	report, err := results.Import(section_dir, []results.Result{
+		{Placement: 1, Points: 300, HasPoints: true, Title: "Second Reality"},
+		{Placement: 2, Title: "Unreal"},
+		{Placement: 3, Title: "Missing Demo"},
+		{Placement: 4, Title: "Stray"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Updated) != 2 {
+		t.Errorf("Expected 2 updated entries, got %v", report.Updated)
+	}
+	if len(report.OnlyInResults) != 2 ||
+		report.OnlyInResults[0].Key != "missing-demo" {
+		t.Errorf("Unexpected results only entries %v", report.OnlyInResults)
+	}
+	if len(report.OnlyInSection) != 1 || report.OnlyInSection[0] != "unranked" {
+		t.Errorf("Unexpected section only entries %v", report.OnlyInSection)
+	}
+	if len(report.Unlisted) != 1 || report.Unlisted[0] != "stray" {
+		t.Errorf("Unexpected unlisted entries %v", report.Unlisted)
+	}
+
+	section := read_json(t, section_dir)
+	entries := section["entries"].([]interface{})
+	if len(entries) != 3 {
+		t.Fatalf("Unexpected entries %v", entries)
+	}
+	if entries[0] != "dir-key" || entries[1] != "unreal" || entries[2] != "unranked" {
+		t.Errorf("Unexpected entry order %v", entries)
+	}
+	if section["is-ranked"] != true {
+		t.Error("Section was not marked as ranked")
+	}
+	first := read_json(t, filepath.Join(section_dir, "dir-key"))
+	if first["placement"] != 1.0 || first["points"] != 300.0 {
+		t.Errorf("Unexpected first entry %v", first)
+	}
+	second := read_json(t, filepath.Join(section_dir, "unreal"))
+	if second["description"] != "Kept" {
+		t.Error("Existing entry metadata was not kept")
+	}
+	unranked := read_json(t, filepath.Join(section_dir, "unranked"))
+	if _, ok := unranked["placement"]; ok {
+		t.Error("Stale placement was not removed")
+	}
+}
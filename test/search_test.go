@@ -0,0 +1,80 @@
+package search_test
+
+import (
+	"base"
+	"search"
+	"testing"
+)
+
+func create_years() []*base.Year {
+	demo := &base.Section{Key: "demo", Name: "Demo", Entries: []*base.Entry{
+		&base.Entry{Key: "aanet", Title: "Äänet", Author: "Jml^vs"},
+		&base.Entry{Key: "second", Title: "Second Reality", Author: "Future Crew"},
+	}}
+	music := &base.Section{Key: "music", Name: "Music", Entries: []*base.Entry{
+		&base.Entry{
+			Key:         "tune",
+			Title:       "Tune",
+			Author:      "Purple Motion",
+			Description: "Soundtrack for Second Reality",
+		},
+	}}
+	return []*base.Year{
+		&base.Year{Key: "1993", Year: 1993, Sections: []*base.Section{demo, music}},
+	}
+}
+
+func require_keys(t *testing.T, results []search.Result, keys ...string) {
+	if len(results) != len(keys) {
+		t.Fatalf("Got %d results, expected %d", len(results), len(keys))
+	}
+	for index, result := range results {
+		if result.Entry.Key != keys[index] {
+			t.Errorf(
+				"Result %d is %s, expected %s",
+				index,
+				result.Entry.Key,
+				keys[index])
+		}
+	}
+}
+
+func TestFinnishLettersShouldBeFolded(t *testing.T) {
+	index := search.New(create_years())
+	require_keys(t, index.Search(search.Query{Text: "aanet"}), "aanet")
+	require_keys(t, index.Search(search.Query{Text: "ÄÄNET"}), "aanet")
+}
+
+func TestSceneHandleShouldBeFoundByPartsAndWhole(t *testing.T) {
+	index := search.New(create_years())
+	require_keys(t, index.Search(search.Query{Text: "jml"}), "aanet")
+	require_keys(t, index.Search(search.Query{Text: "jmlvs"}), "aanet")
+	require_keys(t, index.Search(search.Query{Text: "Jml^vs"}), "aanet")
+}
+
+func TestTitleMatchShouldRankBeforeDescriptionMatch(t *testing.T) {
+	index := search.New(create_years())
+	require_keys(
+		t, index.Search(search.Query{Text: "second real"}), "second", "tune")
+}
+
+func TestSectionFilterShouldLimitResults(t *testing.T) {
+	index := search.New(create_years())
+	require_keys(
+		t,
+		index.Search(search.Query{Text: "second", Section: "music"}),
+		"tune")
+	require_keys(
+		t, index.Search(search.Query{Text: "second", Year: "1994"}))
+}
+
+func TestSuggestionsShouldHaveDistinctTitles(t *testing.T) {
+	index := search.New(create_years())
+	suggestions := index.Suggest("second", 10)
+	if len(suggestions) != 2 {
+		t.Fatalf("Unexpected suggestions %v", suggestions)
+	}
+	if suggestions[0] != "Second Reality" || suggestions[1] != "Tune" {
+		t.Errorf("Unexpected suggestions %v", suggestions)
+	}
+}
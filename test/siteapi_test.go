@@ -0,0 +1,117 @@
+package siteapi_test
+
+import (
+	"base"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"siteapi"
+	"state"
+	"testing"
+)
+
+func create_site_state(entry_count int) *state.SiteState {
+	var entries []*base.Entry
+	for i := 0; i < entry_count; i++ {
+		key := fmt.Sprintf("entry-%d", i)
+		entries = append(entries, &base.Entry{
+			Key:   key,
+			Path:  "/site/2001/demo/" + key,
+			Title: key,
+			Thumbnails: base.Thumbnails{
+				Default: base.ImageInfo{
+					Path:     "/site/_data/2001/demo/" + key + "/thumb.png",
+					FsPath:   "/secret/" + key + "/thumb.png",
+					Checksum: "abcdef",
+					Type:     "image/png",
+					Size:     base.Resolution{X: 160, Y: 90},
+				},
+			},
+		})
+	}
+	site_state := &state.SiteState{}
+	site_state.ReplaceYear(&base.Year{
+		Year: 2001,
+		Key:  "2001",
+		Name: "2001",
+		Path: "/site/2001",
+		Sections: []*base.Section{&base.Section{
+			Key:     "demo",
+			Name:    "Demo",
+			Path:    "/site/2001/demo",
+			Entries: entries,
+		}},
+	})
+	return site_state
+}
+
+func do_request(
+	site_state *state.SiteState,
+	path string,
+	header http.Header) *httptest.ResponseRecorder {
+	settings := base.SiteSettings{SiteRoot: "/site"}
+	request := httptest.NewRequest("GET", "/"+path, nil)
+	request.URL.Path = request.URL.Path[1:]
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	response := httptest.NewRecorder()
+	siteapi.Renderer(settings, site_state)(response, request)
+	return response
+}
+
+func TestSectionShouldBePaginatedWithOffset(t *testing.T) {
+	site_state := create_site_state(45)
+	response := do_request(site_state, "v1/2001/demo?offset=30", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", response.Code, response.Body)
+	}
+	var section siteapi.SectionJson
+	if err := json.Unmarshal(response.Body.Bytes(), &section); err != nil {
+		t.Fatal(err)
+	}
+	if len(section.Entries) != 15 {
+		t.Fatalf("Got %d entries, expected 15", len(section.Entries))
+	}
+	if section.Entries[0].Key != "entry-30" {
+		t.Errorf("First entry is %s, expected entry-30", section.Entries[0].Key)
+	}
+	if section.Pagination.Prev != "/site/_api/v1/2001/demo?offset=0" {
+		t.Errorf("Unexpected previous page %s", section.Pagination.Prev)
+	}
+	if section.Pagination.Next != "" {
+		t.Errorf("Last page has a next page %s", section.Pagination.Next)
+	}
+	thumbnail := section.Entries[0].Thumbnails.Default.Url
+	if thumbnail != "/site/_data/2001/demo/entry-30/thumb.png?abcdef" {
+		t.Errorf("Unexpected thumbnail URL %s", thumbnail)
+	}
+}
+
+func TestMissingEntryShouldResultInNotFound(t *testing.T) {
+	site_state := create_site_state(1)
+	response := do_request(site_state, "v1/2001/demo/missing", nil)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status %d: %s", response.Code, response.Body)
+	}
+}
+
+func TestUnchangedDocumentShouldResultInNotModified(t *testing.T) {
+	site_state := create_site_state(1)
+	response := do_request(site_state, "v1/2001/demo/entry-0", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", response.Code, response.Body)
+	}
+	if response.Header().Get("Cache-Control") == "" {
+		t.Error("Response does not have cache headers")
+	}
+	etag := response.Header().Get("ETag")
+	response = do_request(
+		site_state,
+		"v1/2001/demo/entry-0",
+		http.Header{"If-None-Match": []string{etag}})
+	if response.Code != http.StatusNotModified {
+		t.Errorf("Unexpected status %d with entity tag %s", response.Code, etag)
+	}
+}
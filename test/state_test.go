@@ -0,0 +1,810 @@
+package state_test
+
+import (
+	"assets"
+	"base"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"state"
+	"strings"
+	"testing"
+	"time"
+)
+
+func create_entry_dir(t *testing.T, meta string) string {
+	entry_dir := filepath.Join(t.Name(), "entry")
+	if err := os.RemoveAll(entry_dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(entry_dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	meta_path := filepath.Join(entry_dir, "meta.json")
+	if err := ioutil.WriteFile(meta_path, []byte(meta), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return entry_dir
+}
+
+func create_year(year int, key string, section_keys ...string) *base.Year {
+	var sections []*base.Section
+	for _, section_key := range section_keys {
+		sections = append(sections, &base.Section{
+			Key:  section_key,
+			Name: section_key,
+		})
+	}
+	return &base.Year{
+		Year:     year,
+		Key:      key,
+		Name:     key,
+		Sections: sections,
+	}
+}
+
+func require_year_order(t *testing.T, years []*base.Year, expected []int) {
+	if len(years) != len(expected) {
+		t.Fatalf("Got %d years, expected %d", len(years), len(expected))
+	}
+	for i, year := range years {
+		if year.Year != expected[i] {
+			t.Fatalf(
+				"Year at index %d is %d, expected %d", i, year.Year, expected[i])
+		}
+	}
+}
+
+func TestReplaceYearShouldKeepYearsInReverseOrder(t *testing.T) {
+	site_state := state.SiteState{}
+	site_state.ReplaceYear(create_year(2001, "2001"))
+	site_state.ReplaceYear(create_year(2010, "2010"))
+	site_state.ReplaceYear(create_year(1995, "1995"))
+	site_state.ReplaceYear(create_year(2005, "2005"))
+	require_year_order(t, site_state.Years(), []int{2010, 2005, 2001, 1995})
+
+	replacement := create_year(2005, "2005", "section")
+	site_state.ReplaceYear(replacement)
+	years := site_state.Years()
+	require_year_order(t, years, []int{2010, 2005, 2001, 1995})
+	if years[1] != replacement {
+		t.Fatal("Year 2005 was not replaced")
+	}
+}
+
+func TestReplaceSectionShouldNotModifyPreviousSnapshot(t *testing.T) {
+	site_state := state.SiteState{}
+	site_state.ReplaceYear(create_year(2001, "2001", "first", "second"))
+	old_years := site_state.Years()
+	old_section := old_years[0].Sections[1]
+
+	new_section := &base.Section{Key: "second", Name: "New name"}
+	if err := site_state.ReplaceSection("2001", new_section); err != nil {
+		t.Fatal(err)
+	}
+	if old_years[0].Sections[1] != old_section {
+		t.Error("Previous snapshot was modified")
+	}
+	new_years := site_state.Years()
+	if new_years[0].Sections[1] != new_section {
+		t.Error("Section was not replaced in the new snapshot")
+	}
+	if new_years[0].Sections[0] != old_years[0].Sections[0] {
+		t.Error("Unrelated section was not preserved")
+	}
+}
+
+func TestReplaceSectionShouldFailForMissingYearOrSection(t *testing.T) {
+	site_state := state.SiteState{}
+	site_state.ReplaceYear(create_year(2001, "2001", "first"))
+	section := &base.Section{Key: "missing"}
+	if err := site_state.ReplaceSection("2001", section); err == nil {
+		t.Error("Missing section did not result in an error")
+	}
+	section = &base.Section{Key: "first"}
+	if err := site_state.ReplaceSection("2002", section); err == nil {
+		t.Error("Missing year did not result in an error")
+	}
+}
+
+func TestVimeoEntryWithoutThumbnailsShouldUseVimeoThumbnail(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "vimeo", "data": {"id": "1234567#t=1m30s", "remote-thumbnail": true}}
+}`)
+	entry, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vimeo, ok := entry.Asset.Data.(assets.VimeoAsset)
+	if !ok {
+		t.Fatalf("Asset data is not a Vimeo asset: %v", entry.Asset.Data)
+	}
+	if vimeo.Id != "1234567#t=1m30s" {
+		t.Errorf("Unexpected Vimeo ID %s", vimeo.Id)
+	}
+	if !strings.Contains(entry.Thumbnails.Default.Path, "1234567") {
+		t.Errorf(
+			"Thumbnail %s does not refer to the Vimeo video",
+			entry.Thumbnails.Default.Path)
+	}
+}
+
+func TestVimeoEntryWithoutThumbnailsOrOptInShouldFail(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "vimeo", "data": {"id": "1234567"}}
+}`)
+	_, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err == nil {
+		t.Fatal("Vimeo entry without thumbnails was accepted")
+	}
+}
+
+func TestVimeoEntryWithInvalidIdShouldFail(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "vimeo", "data": {"id": "https://vimeo.com/1234567"}}
+}`)
+	_, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err == nil {
+		t.Fatal("Invalid Vimeo ID was accepted")
+	}
+}
+
+func TestVideoEntryShouldHaveSourcesInEntryDirectory(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "video", "data": {
+  "poster": {"filename": "poster.png", "type": "image/png", "checksum": "abcdef", "size": {"x": 640, "y": 360}},
+  "sources": [
+    {"filename": "video.webm", "type": "video/webm", "checksum": "bcdefg", "size": {"x": 1920, "y": 1080}},
+    {"filename": "video.mp4", "type": "video/mp4", "checksum": "cdefgh", "size": {"x": 1920, "y": 1080}}
+  ]}},
+"thumbnails": {"default": {"filename": "thumb.png", "type": "image/png", "checksum": "abcdef", "size": {"x": 160, "y": 90}}}
+}`)
+	entry, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := entry.Asset.Data.(assets.VideoAsset)
+	if video.Poster.Path != "/_data/2001/section/entry/poster.png" {
+		t.Errorf("Unexpected poster path %s", video.Poster.Path)
+	}
+	if len(video.Sources) != 2 {
+		t.Fatalf("Expected 2 video sources, got %d", len(video.Sources))
+	}
+	if video.Sources[1].Path != "/_data/2001/section/entry/video.mp4" {
+		t.Errorf("Unexpected video path %s", video.Sources[1].Path)
+	}
+	if video.Sources[1].FsPath != filepath.Join(entry_dir, "video.mp4") {
+		t.Errorf("Unexpected video file path %s", video.Sources[1].FsPath)
+	}
+}
+
+func TestAudioEntryShouldAcceptTrackerModules(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "audio", "data": {"sources": [
+  {"filename": "song.ogg", "type": "audio/ogg", "checksum": "abcdef", "duration": 215},
+  {"filename": "song.xm", "type": "audio/x-xm", "checksum": "bcdefg"}
+]}},
+"thumbnails": {"default": {"filename": "thumb.png", "type": "image/png", "checksum": "abcdef", "size": {"x": 160, "y": 90}}}
+}`)
+	entry, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio := entry.Asset.Data.(assets.AudioAsset)
+	if len(audio.Sources) != 2 {
+		t.Fatalf("Expected 2 audio sources, got %d", len(audio.Sources))
+	}
+	if audio.Sources[0].Duration != 215 {
+		t.Errorf("Unexpected duration %d", audio.Sources[0].Duration)
+	}
+	if audio.Sources[1].Path != "/_data/2001/section/entry/song.xm" {
+		t.Errorf("Unexpected audio path %s", audio.Sources[1].Path)
+	}
+}
+
+func TestAudioEntryWithNonAudioTypeShouldFail(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "audio", "data": {"sources": [
+  {"filename": "song.zip", "type": "application/zip", "checksum": "abcdef"}
+]}},
+"thumbnails": {"default": {"filename": "thumb.png", "type": "image/png", "checksum": "abcdef", "size": {"x": 160, "y": 90}}}
+}`)
+	_, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err == nil {
+		t.Fatal("Non-audio file type was accepted")
+	}
+}
+
+func TestEntryWithAssetListShouldHavePrimaryAndExtraAssets(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"assets": [
+  {"type": "youtube", "data": {"id": "abcdefgh"}},
+  {"type": "vimeo", "title": "Party version", "data": {"id": "1234567"}}
+],
+"thumbnails": {"default": {"filename": "thumb.png", "type": "image/png", "checksum": "abcdef", "size": {"x": 160, "y": 90}}}
+}`)
+	entry, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Asset.Type != "youtube" {
+		t.Errorf("Unexpected primary asset type %s", entry.Asset.Type)
+	}
+	if len(entry.ExtraAssets) != 1 {
+		t.Fatalf("Expected 1 extra asset, got %d", len(entry.ExtraAssets))
+	}
+	if entry.ExtraAssets[0].Title != "Party version" {
+		t.Errorf("Unexpected extra asset title %s", entry.ExtraAssets[0].Title)
+	}
+}
+
+func TestEntryWithBothAssetAndAssetsShouldFail(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "youtube", "data": {"id": "abcdefgh"}},
+"assets": [{"type": "vimeo", "data": {"id": "1234567"}}],
+"thumbnails": {"default": {"filename": "thumb.png", "type": "image/png", "checksum": "abcdef", "size": {"x": 160, "y": 90}}}
+}`)
+	_, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err == nil {
+		t.Fatal("Entry with both asset and assets was accepted")
+	}
+}
+
+func TestSubscribersShouldGetReplacedSections(t *testing.T) {
+	site_state := state.SiteState{}
+	site_state.ReplaceYear(create_year(2001, "2001", "first"))
+	var published []*base.Year
+	site_state.Subscribe(func(years []*base.Year) {
+		published = years
+	})
+	require_year_order(t, published, []int{2001})
+	section := &base.Section{Key: "first", Name: "Replaced"}
+	if err := site_state.ReplaceSection("2001", section); err != nil {
+		t.Fatal(err)
+	}
+	if published[0].Sections[0].Name != "Replaced" {
+		t.Errorf(
+			"Subscriber did not get the replaced section: %s",
+			published[0].Sections[0].Name)
+	}
+}
+
+func TestSubscribersShouldBeAbleToReadState(t *testing.T) {
+	site_state := state.SiteState{}
+	var published []*base.Year
+	site_state.Subscribe(func(years []*base.Year) {
+		// This would deadlock if listeners were called with the state
+		// locked.
+		published = site_state.Years()
+	})
+	site_state.ReplaceYear(create_year(2001, "2001", "first"))
+	require_year_order(t, published, []int{2001})
+}
+
+func TestEntryWithCreditsShouldHaveAuthorText(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"credits": {
+  "groups": [{"name": "Future Crew", "members": [
+    {"name": "Purple Motion", "aliases": ["PM"], "roles": ["music"]}
+  ]}],
+  "persons": [{"name": "Skaven", "roles": ["music"]}]
+},
+"asset": {"type": "vimeo", "data": {"id": "1234567", "remote-thumbnail": true}}
+}`)
+	entry, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Author != "Future Crew & Skaven" {
+		t.Errorf("Unexpected author %s", entry.Author)
+	}
+	member := entry.Credits.Groups[0].Members[0]
+	if member.Aliases[0] != "PM" || member.Roles[0] != "music" {
+		t.Errorf("Unexpected member credit %v", member)
+	}
+}
+
+func TestPersonWithMembersShouldFail(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"credits": {"persons": [{"name": "Skaven", "members": [{"name": "Other"}]}]},
+"asset": {"type": "youtube", "data": {"id": "abcdefgh"}}
+}`)
+	_, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err == nil {
+		t.Fatal("Person with members was accepted")
+	}
+}
+
+func TestSectionShouldHaveDeclaredCompetitionFamily(t *testing.T) {
+	section_dir := create_entry_dir(
+		t, `{"name": "Demo", "family": "pc-demo", "entries": []}`)
+	section, err := state.ReadSectionMetaFiles(
+		section_dir, "/_data/1995/demo", "/1995/demo", "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if section.Family != "pc-demo" {
+		t.Errorf("Unexpected competition family %s", section.Family)
+	}
+
+	section_dir = create_entry_dir(
+		t, `{"name": "Demo", "family": "PC Demo", "entries": []}`)
+	_, err = state.ReadSectionMetaFiles(
+		section_dir, "/_data/1995/demo", "/1995/demo", "demo")
+	if err == nil {
+		t.Error("Invalid competition family was accepted")
+	}
+}
+
+func create_ranked_section(rankings ...base.Ranking) *base.Section {
+	section := &base.Section{Key: "section", IsRanked: true}
+	for index, ranking := range rankings {
+		section.Entries = append(section.Entries, &base.Entry{
+			Key:     string(rune('a' + index)),
+			Ranking: ranking,
+		})
+	}
+	return section
+}
+
+func TestRankingWarningsShouldReportDisagreeingPlacements(t *testing.T) {
+	section := create_ranked_section(
+		base.Ranking{Placement: 1},
+		base.Ranking{Placement: 2, SharedPlace: true},
+		base.Ranking{Placement: 2, SharedPlace: true},
+		base.Ranking{Placement: 4})
+	if warnings := state.RankingWarnings(section); len(warnings) != 0 {
+		t.Errorf("Consistent placements got warnings: %v", warnings)
+	}
+
+	section = create_ranked_section(
+		base.Ranking{Placement: 2},
+		base.Ranking{Placement: 1},
+		base.Ranking{Placement: 1},
+		base.Ranking{})
+	warnings := state.RankingWarnings(section)
+	if len(warnings) != 3 {
+		t.Fatalf("Expected 3 warnings, got %v", warnings)
+	}
+	if !strings.Contains(warnings[2], "without placement: d") {
+		t.Errorf("Missing placement was not reported: %s", warnings[2])
+	}
+
+	section.IsOngoing = true
+	if warnings := state.RankingWarnings(section); len(warnings) != 0 {
+		t.Errorf("Ongoing section got warnings: %v", warnings)
+	}
+}
+
+func write_png(t *testing.T, filename string, width int, height int) {
+	output, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+	if err := png.Encode(output, source); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImageInformationShouldBeReadFromFiles(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"asset": {"type": "image", "data": {"default": {"filename": "image.png"}}},
+"thumbnails": {"default": {"filename": "thumb.png", "type": "image/png"}}
+}`)
+	write_png(t, filepath.Join(entry_dir, "image.png"), 64, 48)
+	write_png(t, filepath.Join(entry_dir, "thumb.png"), 160, 90)
+	entry, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image_asset := entry.Asset.Data.(assets.ImageAsset)
+	if image_asset.Default.Size != (base.Resolution{X: 64, Y: 48}) ||
+		image_asset.Default.Type != "image/png" ||
+		len(image_asset.Default.Checksum) != 6 {
+		t.Errorf("Image information was not read: %v", image_asset.Default)
+	}
+	if entry.Thumbnails.Default.Size != (base.Resolution{X: 160, Y: 90}) {
+		t.Errorf(
+			"Thumbnail size was not read: %v", entry.Thumbnails.Default.Size)
+	}
+
+	entry_dir = create_entry_dir(t, `{
+"title": "Title",
+"thumbnails": {"default": {"filename": "thumb.png", "size": {"x": 320, "y": 180}}}
+}`)
+	write_png(t, filepath.Join(entry_dir, "thumb.png"), 160, 90)
+	_, err = state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err == nil {
+		t.Fatal("Wrong thumbnail size did not result in an error")
+	}
+	thumbnail_path := filepath.Join(entry_dir, "thumb.png")
+	if !strings.Contains(err.Error(), thumbnail_path) {
+		t.Errorf("Error does not include the image path: %v", err)
+	}
+}
+
+func TestCompleteImageInformationShouldOnlyBeVerifiedOnImport(t *testing.T) {
+	entry_dir := create_entry_dir(t, `{
+"title": "Title",
+"thumbnails": {"default": {
+  "filename": "thumb.png", "type": "image/png",
+  "checksum": "abcdef", "size": {"x": 160, "y": 90}}}
+}`)
+	write_png(t, filepath.Join(entry_dir, "thumb.png"), 160, 90)
+	entry, err := state.ReadEntry(
+		entry_dir, "/_data/2001/section/entry", "/2001/section/entry", "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	section := &base.Section{Entries: []*base.Entry{entry}}
+	if err := state.VerifySectionFiles(section); err == nil {
+		t.Error("Wrong thumbnail checksum was not noticed on import")
+	}
+}
+
+func write_meta(t *testing.T, directory string, meta string) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		t.Fatal(err)
+	}
+	meta_path := filepath.Join(directory, "meta.json")
+	if err := ioutil.WriteFile(meta_path, []byte(meta), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateShouldReportAllProblems(t *testing.T) {
+	data_dir := filepath.Join(t.Name(), "data")
+	if err := os.RemoveAll(data_dir); err != nil {
+		t.Fatal(err)
+	}
+	section_dir := filepath.Join(data_dir, "2001", "demo")
+	write_meta(t, filepath.Join(data_dir, "2001"), `{"sections": ["demo", "Bad"]}`)
+	write_meta(t, section_dir, `{"name": "Demo", "entries": ["good", "midi", "moved"]}`)
+	write_meta(t, filepath.Join(section_dir, "good"), `{
+"title": "Good",
+"thumbnails": {"default": {"filename": "thumb.png", "checksum": "abcdef"}}
+}`)
+	write_png(t, filepath.Join(section_dir, "good", "thumb.png"), 160, 90)
+	write_meta(t, filepath.Join(section_dir, "midi"), `{
+"title": "Midi",
+"asset": {"type": "midi", "data": {}}
+}`)
+	if err := os.MkdirAll(filepath.Join(section_dir, "orphan"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	report := state.Validate(data_dir)
+	if report.Years != 1 || report.Sections != 1 || report.Entries != 3 {
+		t.Errorf(
+			"Unexpected counts %d/%d/%d",
+			report.Years,
+			report.Sections,
+			report.Entries)
+	}
+	expected := []string{
+		state.PROBLEM_CHECKSUM_MISMATCH,
+		state.PROBLEM_UNKNOWN_ASSET_TYPE,
+		state.PROBLEM_MISSING_FILE,
+		state.PROBLEM_ORPHAN_DIRECTORY,
+		state.PROBLEM_INVALID_KEY,
+	}
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), report.Problems)
+	}
+	for index, kind := range expected {
+		if report.Problems[index].Kind != kind {
+			t.Errorf(
+				"Problem %d is %v, expected %s",
+				index,
+				report.Problems[index],
+				kind)
+		}
+	}
+}
+
+func TestValidateShouldVerifyVideoAndAudioFiles(t *testing.T) {
+	data_dir := filepath.Join(t.Name(), "data")
+	if err := os.RemoveAll(data_dir); err != nil {
+		t.Fatal(err)
+	}
+	section_dir := filepath.Join(data_dir, "2001", "demo")
+	write_meta(t, filepath.Join(data_dir, "2001"), `{"sections": ["demo"]}`)
+	write_meta(t, section_dir, `{"name": "Demo", "entries": ["video", "music"]}`)
+	write_meta(t, filepath.Join(section_dir, "video"), `{
+"title": "Video",
+"asset": {"type": "video", "data": {"sources": [
+  {"filename": "video.mp4", "type": "video/mp4", "checksum": "abcdef",
+   "size": {"x": 640, "y": 360}}]}}
+}`)
+	write_meta(t, filepath.Join(section_dir, "music"), `{
+"title": "Music",
+"asset": {"type": "audio", "data": {"sources": [
+  {"filename": "music.ogg", "type": "audio/ogg", "checksum": "abcdef"}]}}
+}`)
+	music_path := filepath.Join(section_dir, "music", "music.ogg")
+	if err := ioutil.WriteFile(music_path, []byte("music"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	report := state.Validate(data_dir)
+	if len(report.Problems) != 2 ||
+		report.Problems[0].Kind != state.PROBLEM_MISSING_FILE ||
+		report.Problems[1].Kind != state.PROBLEM_CHECKSUM_MISMATCH ||
+		report.Problems[1].Path != music_path {
+		t.Errorf("Unexpected problems %v", report.Problems)
+	}
+}
+
+func TestValidateShouldReportRankingWarnings(t *testing.T) {
+	data_dir := filepath.Join(t.Name(), "data")
+	if err := os.RemoveAll(data_dir); err != nil {
+		t.Fatal(err)
+	}
+	section_dir := filepath.Join(data_dir, "2001", "demo")
+	write_meta(t, filepath.Join(data_dir, "2001"), `{"sections": ["demo"]}`)
+	write_meta(t, section_dir, `{"name": "Demo", "is-ranked": true, "entries": ["second", "first"]}`)
+	for index, key := range []string{"second", "first"} {
+		write_meta(t, filepath.Join(section_dir, key), fmt.Sprintf(`{
+"title": "Title",
+"placement": %d,
+"thumbnails": {"default": {"filename": "thumb.png"}}
+}`, 2-index))
+		write_png(t, filepath.Join(section_dir, key, "thumb.png"), 160, 90)
+	}
+
+	report := state.Validate(data_dir)
+	if len(report.Problems) != 0 {
+		t.Errorf("Unexpected problems %v", report.Problems)
+	}
+	if len(report.Warnings) != 1 || report.Warnings[0].Kind != state.WARNING_RANKING {
+		t.Errorf("Unexpected warnings %v", report.Warnings)
+	}
+}
+
+func TestBrokenSectionShouldBeQuarantinedUntilReloaded(t *testing.T) {
+	data_dir := filepath.Join(t.Name(), "data")
+	if err := os.RemoveAll(data_dir); err != nil {
+		t.Fatal(err)
+	}
+	year_dir := filepath.Join(data_dir, "2001")
+	write_meta(t, year_dir, `{"sections": ["first", "broken", "last"]}`)
+	write_meta(t, filepath.Join(year_dir, "first"), `{"name": "First"}`)
+	write_meta(t, filepath.Join(year_dir, "broken"), `{"name": `)
+	write_meta(t, filepath.Join(year_dir, "last"), `{"name": "Last"}`)
+	write_meta(t, filepath.Join(data_dir, "2002"), `{"sections": `)
+
+	site_state, err_state := state.New(data_dir, "")
+	if err_state != nil {
+		t.Fatal(err_state)
+	}
+	years := site_state.Years()
+	if len(years) != 1 || len(years[0].Sections) != 2 {
+		t.Fatalf("Broken year and section were not left out: %v", years)
+	}
+	load_errors := site_state.LoadErrors()
+	if len(load_errors) != 2 ||
+		load_errors[0].Year != "2002" ||
+		load_errors[0].Section != "" ||
+		load_errors[1].Section != "broken" {
+		t.Fatalf("Unexpected load errors %v", load_errors)
+	}
+
+	write_meta(t, filepath.Join(year_dir, "broken"), `{"name": "Fixed"}`)
+	if err := site_state.UpdateSection("2001", "broken"); err != nil {
+		t.Fatal(err)
+	}
+	sections := site_state.GetYear("2001").Sections
+	if len(sections) != 3 || sections[1].Name != "Fixed" {
+		t.Errorf("Fixed section was not added back to its place")
+	}
+	if len(site_state.LoadErrors()) != 1 {
+		t.Errorf("Load error was not cleared: %v", site_state.LoadErrors())
+	}
+
+	// Reloading the whole year quarantines broken sections again
+	// instead of failing.
+	write_meta(t, filepath.Join(year_dir, "last"), `{"name": `)
+	if err := site_state.UpdateYear("2001"); err != nil {
+		t.Fatal(err)
+	}
+	if sections := site_state.GetYear("2001").Sections; len(sections) != 2 {
+		t.Errorf("Broken section was not left out: %v", sections)
+	}
+	load_errors = site_state.LoadErrors()
+	if len(load_errors) != 2 || load_errors[1].Section != "last" {
+		t.Errorf("Unexpected load errors %v", load_errors)
+	}
+}
+
+func TestSectionCacheShouldNotBeUsedAfterEntryChanges(t *testing.T) {
+	section_dir := filepath.Join(t.Name(), "section")
+	if err := os.RemoveAll(section_dir); err != nil {
+		t.Fatal(err)
+	}
+	entry_meta := `{
+"title": "Title %s",
+"asset": {"type": "vimeo", "data": {"id": "1234567", "remote-thumbnail": true}}
+}`
+	write_meta(t, section_dir, `{"name": "Section", "entries": ["entry"]}`)
+	entry_dir := filepath.Join(section_dir, "entry")
+	write_meta(t, entry_dir, fmt.Sprintf(entry_meta, "A"))
+	read_section := func() *base.Section {
+		section, err := state.ReadSection(
+			section_dir, "/_data/2001/section", "/2001/section", "section")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return section
+	}
+	read_section()
+	if _, err := state.ReadSectionMetaCache(section_dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copied files with the same contents keep the cache valid.
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(entry_dir, "meta.json"), later, later)
+	if _, err := state.ReadSectionMetaCache(section_dir); err != nil {
+		t.Errorf("Cache was not used after a timestamp change: %v", err)
+	}
+	// Cache is rewritten with the new time. Section data is skipped
+	// when decoding only the fingerprints.
+	cache_data, err_cache := ioutil.ReadFile(
+		filepath.Join(section_dir, "meta.aggregate.gob"))
+	if err_cache != nil {
+		t.Fatal(err_cache)
+	}
+	var cache struct {
+		Fingerprints []state.MetaFingerprint
+	}
+	err_decode := gob.NewDecoder(bytes.NewReader(cache_data)).Decode(&cache)
+	if err_decode != nil {
+		t.Fatal(err_decode)
+	}
+	if len(cache.Fingerprints) != 2 ||
+		cache.Fingerprints[1].ModTime != later.UnixNano() {
+		t.Errorf("Cache fingerprints were not refreshed: %v", cache.Fingerprints)
+	}
+
+	write_meta(t, entry_dir, fmt.Sprintf(entry_meta, "B"))
+	if _, err := state.ReadSectionMetaCache(section_dir); err == nil {
+		t.Error("Cache was used after the entry title changed")
+	}
+	if title := read_section().Entries[0].Title; title != "Title B" {
+		t.Errorf("Section has stale entry title %s", title)
+	}
+}
+
+func TestSectionCacheShouldNotBeUsedAfterImageChanges(t *testing.T) {
+	section_dir := filepath.Join(t.Name(), "section")
+	if err := os.RemoveAll(section_dir); err != nil {
+		t.Fatal(err)
+	}
+	write_meta(t, section_dir, `{"name": "Section", "entries": ["entry"]}`)
+	entry_dir := filepath.Join(section_dir, "entry")
+	write_meta(t, entry_dir, `{
+"title": "Title",
+"thumbnails": {"default": {"filename": "thumb.png"}}
+}`)
+	write_png(t, filepath.Join(entry_dir, "thumb.png"), 160, 90)
+	_, err := state.ReadSection(
+		section_dir, "/_data/2001/section", "/2001/section", "section")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.ReadSectionMetaCache(section_dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Size of the thumbnail was read from the replaced file.
+	write_png(t, filepath.Join(entry_dir, "thumb.png"), 320, 180)
+	if _, err := state.ReadSectionMetaCache(section_dir); err == nil {
+		t.Error("Cache was used after the thumbnail changed")
+	}
+}
+
+func TestArchiveIndexShouldBeUsedUntilMetadataChanges(t *testing.T) {
+	data_dir := filepath.Join(t.Name(), "data")
+	if err := os.RemoveAll(data_dir); err != nil {
+		t.Fatal(err)
+	}
+	index_path := filepath.Join(t.Name(), "index.gob")
+	section_dir := filepath.Join(data_dir, "2001", "section")
+	write_meta(t, filepath.Join(data_dir, "2001"), `{"sections": ["section"]}`)
+	write_meta(t, section_dir, `{"name": "Old name"}`)
+	if _, err := state.NewIndexed(data_dir, "", index_path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Broken section cache would be removed if it was read.
+	cache_path := filepath.Join(section_dir, "meta.aggregate.gob")
+	if err := ioutil.WriteFile(cache_path, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.NewIndexed(data_dir, "", index_path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cache_path); err != nil {
+		t.Errorf("Section cache was read instead of the archive index")
+	}
+
+	write_meta(t, section_dir, `{"name": "New name"}`)
+	site_state, err_state := state.NewIndexed(data_dir, "", index_path)
+	if err_state != nil {
+		t.Fatal(err_state)
+	}
+	if name := site_state.GetYear("2001").Sections[0].Name; name != "New name" {
+		t.Errorf("Changed section has stale name %s", name)
+	}
+}
+
+func TestArchiveIndexShouldNotBeUsedForOtherDataOrChangedImages(t *testing.T) {
+	if err := os.RemoveAll(t.Name()); err != nil {
+		t.Fatal(err)
+	}
+	index_path := filepath.Join(t.Name(), "index.gob")
+	write_data := func(data_dir string, width int, height int) {
+		section_dir := filepath.Join(data_dir, "2001", "section")
+		write_meta(t, filepath.Join(data_dir, "2001"), `{"sections": ["section"]}`)
+		write_meta(t, section_dir, `{"name": "Section", "entries": ["entry"]}`)
+		write_meta(t, filepath.Join(section_dir, "entry"), `{
+"title": "Title",
+"thumbnails": {"default": {"filename": "thumb.png"}}
+}`)
+		write_png(t, filepath.Join(section_dir, "entry", "thumb.png"), width, height)
+	}
+	read_thumbnail := func(data_dir string) base.ImageInfo {
+		site_state, err := state.NewIndexed(data_dir, "", index_path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := site_state.GetYear("2001").Sections[0].Entries[0]
+		return entry.Thumbnails.Default
+	}
+	first_dir := filepath.Join(t.Name(), "first")
+	second_dir := filepath.Join(t.Name(), "second")
+	write_data(first_dir, 160, 90)
+	write_data(second_dir, 160, 90)
+	read_thumbnail(first_dir)
+
+	thumbnail := read_thumbnail(second_dir)
+	if !strings.HasPrefix(thumbnail.FsPath, second_dir) {
+		t.Errorf("Index of other data directory was used: %s", thumbnail.FsPath)
+	}
+
+	write_png(t, filepath.Join(second_dir, "2001", "section", "entry", "thumb.png"), 320, 180)
+	thumbnail = read_thumbnail(second_dir)
+	if thumbnail.Size != (base.Resolution{X: 320, Y: 180}) {
+		t.Errorf("Index was used after the thumbnail changed: %v", thumbnail.Size)
+	}
+}
@@ -0,0 +1,80 @@
+package watcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"state"
+	"testing"
+	"time"
+	"watcher"
+)
+
+func write_file(t *testing.T, filename string, data string) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func create_data_dir(t *testing.T) string {
+	data_dir := filepath.Join(t.Name(), "data")
+	if err := os.RemoveAll(data_dir); err != nil {
+		t.Fatal(err)
+	}
+	write_file(
+		t,
+		filepath.Join(data_dir, "2001", "meta.json"),
+		`{"sections": ["section"]}`)
+	write_file(
+		t,
+		filepath.Join(data_dir, "2001", "section", "meta.json"),
+		`{"name": "Name", "entries": []}`)
+	return data_dir
+}
+
+func require_section_name(t *testing.T, site_state *state.SiteState, name string) {
+	section_name := site_state.GetYear("2001").Sections[0].Name
+	if section_name != name {
+		t.Fatalf("Section name is '%s', expected '%s'", section_name, name)
+	}
+}
+
+func TestChangedSectionShouldBeReloadedAfterQuietPoll(t *testing.T) {
+	data_dir := create_data_dir(t)
+	site_state, err_state := state.New(data_dir, "")
+	if err_state != nil {
+		t.Fatal(err_state)
+	}
+	data_watcher := watcher.New(site_state, time.Second)
+
+	write_file(
+		t,
+		filepath.Join(data_dir, "2001", "section", "meta.json"),
+		`{"name": "Changed name", "entries": []}`)
+	data_watcher.Poll()
+	require_section_name(t, site_state, "Name")
+	data_watcher.Poll()
+	require_section_name(t, site_state, "Changed name")
+}
+
+func TestNewYearShouldBeLoaded(t *testing.T) {
+	data_dir := create_data_dir(t)
+	site_state, err_state := state.New(data_dir, "")
+	if err_state != nil {
+		t.Fatal(err_state)
+	}
+	data_watcher := watcher.New(site_state, time.Second)
+
+	write_file(
+		t,
+		filepath.Join(data_dir, "2002", "meta.json"),
+		`{"sections": []}`)
+	data_watcher.Poll()
+	data_watcher.Poll()
+	if site_state.GetYear("2002") == nil {
+		t.Fatal("New year was not loaded")
+	}
+}